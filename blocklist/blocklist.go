@@ -0,0 +1,230 @@
+package blocklist
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"ipvoid/config"
+	"ipvoid/jail"
+	"ipvoid/voidlog"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Status is the state of a blocklist, for the web interface
+type Status struct {
+	Name    string
+	URL     string
+	Entries int //in the firewall
+	Invalid int //lines that are neither an IP nor a CIDR
+	Updated time.Time
+	Err     string //of the last load, the previous entries are kept
+}
+
+// list loads a blocklist on its schedule until stop is closed
+type list struct {
+	cfg  config.Blocklist
+	stop chan struct{}
+
+	mu      sync.Mutex //serializes loads with the removal of the list
+	removed bool
+	status  Status
+}
+
+var lists = make(map[string]*list)
+var lock = sync.RWMutex{}
+
+var client = &http.Client{Timeout: time.Minute}
+
+// Setup starts loading the configured blocklists. Lists whose settings
+// didn't change keep their schedule, the entries of removed lists are
+// taken out of the firewall.
+func Setup(cfgs []config.Blocklist) {
+	lock.Lock()
+	defer lock.Unlock()
+
+	next := make(map[string]*list, len(cfgs))
+	for _, cfg := range cfgs {
+		if l, ok := lists[cfg.Name]; ok && l.cfg == cfg {
+			next[cfg.Name] = l
+			delete(lists, cfg.Name)
+			continue
+		}
+		next[cfg.Name] = nil
+	}
+
+	//stopped lists, and lists with new settings, start over
+	for name, l := range lists {
+		close(l.stop)
+		l.mu.Lock()
+		l.removed = true
+		if next[name] == nil {
+			_, removed, _ := jail.SetList(name, nil)
+			voidlog.Logf("Blocklist %s removed, %d entries released \n", name, removed)
+		}
+		l.mu.Unlock()
+	}
+
+	for _, cfg := range cfgs {
+		if next[cfg.Name] != nil {
+			continue
+		}
+		l := &list{cfg: cfg, stop: make(chan struct{}), status: Status{Name: cfg.Name, URL: cfg.URL}}
+		next[cfg.Name] = l
+		go l.run()
+	}
+	lists = next
+}
+
+// Lists returns the status of the blocklists, sorted by name
+func Lists() []Status {
+	lock.RLock()
+	defer lock.RUnlock()
+
+	res := make([]Status, 0, len(lists))
+	for _, l := range lists {
+		l.mu.Lock()
+		res = append(res, l.status)
+		l.mu.Unlock()
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Name < res[j].Name
+	})
+	return res
+}
+
+func (l *list) run() {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-timer.C:
+			l.load()
+			timer.Reset(l.cfg.RefreshTime)
+		}
+	}
+}
+
+// load reads the list and installs its changes in the firewall
+func (l *list) load() {
+	entries, invalid, err := Read(l.cfg)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.removed {
+		return
+	}
+
+	if err != nil {
+		l.status.Err = err.Error()
+		voidlog.Logf("Blocklist %s: %s \n", l.cfg.Name, err.Error())
+		return
+	}
+
+	added, removed, err := jail.SetList(l.cfg.Name, entries)
+	l.status.Err = ""
+	if err != nil {
+		l.status.Err = err.Error()
+		voidlog.Logf("Blocklist %s: %s \n", l.cfg.Name, err.Error())
+	}
+	l.status.Entries = jail.ListSize(l.cfg.Name)
+	l.status.Invalid = invalid
+	l.status.Updated = time.Now()
+	voidlog.Logf("Blocklist %s: %d entries, %d added, %d removed, %d invalid lines \n",
+		l.cfg.Name, l.status.Entries, added, removed, invalid)
+}
+
+// Read fetches a blocklist from its URL or file and parses it
+func Read(cfg config.Blocklist) (entries []string, invalid int, err error) {
+	var r io.ReadCloser
+	if strings.HasPrefix(cfg.URL, "http://") || strings.HasPrefix(cfg.URL, "https://") {
+		resp, err := client.Get(cfg.URL)
+		if err != nil {
+			return nil, 0, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, 0, errors.New(cfg.URL + ": " + resp.Status)
+		}
+		r = resp.Body
+	} else {
+		r, err = os.Open(cfg.URL)
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+	defer r.Close()
+
+	return Parse(r, cfg.Format, cfg.Column)
+}
+
+// Parse reads the IPs and CIDRs of a blocklist, one per line. Text lists
+// have the entry as the first word of a line (plain lists, FireHOL netsets,
+// Spamhaus DROP), CSV lists in column (from 1). Comments start with # or ;.
+// Lines without a valid entry are counted in invalid.
+func Parse(r io.Reader, format string, column int) (entries []string, invalid int, err error) {
+	if column < 1 {
+		column = 1
+	}
+
+	seen := make(map[string]bool)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.IndexAny(line, "#;"); i != -1 {
+			line = line[:i]
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		var entry string
+		if format == "csv" {
+			cols := strings.Split(line, ",")
+			if len(cols) >= column {
+				entry = strings.Trim(strings.TrimSpace(cols[column-1]), `"`)
+			}
+		} else {
+			entry = strings.Fields(line)[0]
+		}
+
+		entry, ok := normalize(entry)
+		if !ok {
+			invalid++
+			continue
+		}
+		if !seen[entry] {
+			seen[entry] = true
+			entries = append(entries, entry)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, 0, fmt.Errorf("reading the list: %s", err.Error())
+	}
+	return entries, invalid, nil
+}
+
+// normalize returns entry as an address or a CIDR, a CIDR of a single
+// address is returned as the address
+func normalize(entry string) (string, bool) {
+	if ip := net.ParseIP(entry); ip != nil {
+		return ip.String(), true
+	}
+	_, ipnet, err := net.ParseCIDR(entry)
+	if err != nil {
+		return "", false
+	}
+	if ones, bits := ipnet.Mask.Size(); ones == bits {
+		return ipnet.IP.String(), true
+	}
+	return ipnet.String(), true
+}
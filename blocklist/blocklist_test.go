@@ -0,0 +1,114 @@
+package blocklist
+
+import (
+	"fmt"
+	"io/ioutil"
+	"ipvoid/config"
+	"ipvoid/jail"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	drop := `; Spamhaus DROP List 2020/10/10
+1.10.16.0/20 ; SBL256894
+1.19.0.0/16 ; SBL434604
+`
+	entries, invalid, err := Parse(strings.NewReader(drop), "", 0)
+	if err != nil || invalid != 0 || fmt.Sprint(entries) != "[1.10.16.0/20 1.19.0.0/16]" {
+		t.Fatalf("unexpected DROP entries %v, %d invalid, %v \n", entries, invalid, err)
+	}
+
+	netset := `#
+# firehol_level1
+#
+0.0.0.0/8
+192.0.2.7
+192.0.2.7/32
+2001:db8::/32
+1.2.3.4-1.2.3.9
+`
+	entries, invalid, err = Parse(strings.NewReader(netset), "text", 0)
+	if err != nil || invalid != 1 || fmt.Sprint(entries) != "[0.0.0.0/8 192.0.2.7 2001:db8::/32]" {
+		t.Fatalf("unexpected netset entries %v, %d invalid, %v \n", entries, invalid, err)
+	}
+
+	csv := `"first_seen","ip","port"
+"2020-10-10","198.51.100.1","443"
+"2020-10-10","198.51.100.2","8080"
+`
+	entries, invalid, err = Parse(strings.NewReader(csv), "csv", 2)
+	if err != nil || invalid != 1 || fmt.Sprint(entries) != "[198.51.100.1 198.51.100.2]" {
+		t.Fatalf("unexpected CSV entries %v, %d invalid, %v \n", entries, invalid, err)
+	}
+}
+
+func TestSetup(t *testing.T) {
+	dir, err := ioutil.TempDir("", "blocklist")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "local.txt")
+	ioutil.WriteFile(path, []byte("203.0.113.0/24\n"), 0644)
+
+	var servedLock sync.Mutex
+	served := "198.51.100.0/24\n198.18.0.0/15\n"
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		servedLock.Lock()
+		defer servedLock.Unlock()
+		fmt.Fprint(w, served)
+	}))
+	defer ts.Close()
+
+	jail.Setup(jail.DryRunFirewall{})
+	cfgs := []config.Blocklist{
+		{Name: "local", URL: path, RefreshTime: time.Hour},
+		{Name: "remote", URL: ts.URL, RefreshTime: 50 * time.Millisecond},
+	}
+	Setup(cfgs)
+	defer Setup(nil)
+
+	wait := func(cond func() bool) bool {
+		for i := 0; i < 100; i++ {
+			if cond() {
+				return true
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		return false
+	}
+
+	if !wait(func() bool { return jail.ListSize("local") == 1 && jail.ListSize("remote") == 2 }) {
+		t.Fatalf("lists not loaded: %+v \n", Lists())
+	}
+	if res := fmt.Sprint(jail.Listed("198.51.100.20")); res != "[remote]" {
+		t.Fatalf("unexpected lists of 198.51.100.20: %s \n", res)
+	}
+
+	//the remote list is reloaded on its schedule
+	servedLock.Lock()
+	served = "198.18.0.0/15\n"
+	servedLock.Unlock()
+	if !wait(func() bool { return jail.ListSize("remote") == 1 }) {
+		t.Fatalf("list not reloaded: %+v \n", Lists())
+	}
+
+	status := Lists()
+	if len(status) != 2 || status[0].Name != "local" || status[0].Entries != 1 || status[0].Updated.IsZero() {
+		t.Fatalf("unexpected status: %+v \n", status)
+	}
+
+	//removed lists are released
+	Setup(cfgs[:1])
+	if jail.ListSize("remote") != 0 || jail.ListSize("local") != 1 {
+		t.Fatalf("remote list not released \n")
+	}
+}
@@ -0,0 +1,46 @@
+package checkconfig
+
+import (
+	"flag"
+	"fmt"
+	"ipvoid/config"
+	"os"
+)
+
+const usage = `usage: ipvoid check-config [-config file]
+
+Validates the configuration and the rules files it refers to, and prints every
+problem with its file and line. Exits with 1 when the configuration is invalid.
+`
+
+// Main runs "ipvoid check-config" and returns the exit code
+func Main(args []string) int {
+	flags := flag.NewFlagSet("check-config", flag.ContinueOnError)
+	configFile := flags.String("config", "config.json", "configuration file")
+	flags.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+		flags.PrintDefaults()
+	}
+	if flags.Parse(args) != nil || flags.NArg() > 0 {
+		return 2
+	}
+
+	err := config.Check(*configFile)
+	if err != nil {
+		for _, p := range Problems(err) {
+			fmt.Fprintln(os.Stderr, p)
+		}
+		return 1
+	}
+
+	fmt.Printf("%s: OK\n", *configFile)
+	return 0
+}
+
+// Problems splits a configuration error into its problems
+func Problems(err error) []string {
+	if verr, ok := err.(*config.ValidationError); ok {
+		return verr.Problems
+	}
+	return []string{err.Error()}
+}
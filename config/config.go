@@ -1,16 +1,61 @@
 package config
 
 import (
-	"bufio"
+	"bytes"
 	"encoding/json"
-	"io"
-	"log"
-	"os"
-	"regexp"
+	"errors"
+	"io/ioutil"
+	"reflect"
 	"strconv"
 	"strings"
+	"sync/atomic"
+	"time"
 )
 
+// Source is a watched log file with its own IP extraction and rules.
+// Empty fields are inherited from the top level configuration.
+type Source struct {
+	LogFile      string
+	Format       string
+	IpField      string
+	IpRegEx      string
+	RulesFile    string
+	BanThreshold int
+	Rules        []Rule `json:"-"`
+}
+
+// Notifier sends jail events to a webhook, a local SMTP relay or syslog
+type Notifier struct {
+	Type       string   //"webhook", "smtp" or "syslog"
+	Events     []string //ban, unban, repeat-offender, geo-block, asn-block. Empty means all
+	URL        string
+	Secret     string //webhook HMAC-SHA256 key
+	SMTPServer string
+	From       string
+	To         []string
+	Retries    *int //default 3, 0 turns retries off
+	QueueSize  int
+}
+
+// DNSBL is a DNS blocklist zone. The points of the IPs it lists are
+// multiplied according to the returned code.
+type DNSBL struct {
+	Zone        string         //e.g. "zen.example"
+	Multipliers map[string]int //return code ("127.0.0.2"): multiplier
+	Multiplier  int            //for the other return codes, 0 ignores them
+}
+
+// Blocklist is an external list of IPs and CIDRs, banned ahead of any log
+// match and kept up to date on a schedule
+type Blocklist struct {
+	Name        string
+	URL         string        //http(s) URL or local file
+	Format      string        //"text" (default): the first word of a line, "csv": Column of a line
+	Column      int           //CSV column of the entries, from 1
+	Refresh     string        //reload interval, default "1d"
+	RefreshTime time.Duration `json:"-"` //parsed Refresh
+}
+
 type Configuration struct {
 	LogFile                            string
 	IpRegEx                            string
@@ -19,7 +64,7 @@ type Configuration struct {
 	DecreasePerMinute                  float32
 	IPWhitelist                        string
 	CIDRWhitelist                      []string
-	Rules                              map[*regexp.Regexp]int
+	Rules                              []Rule
 	UseProxyDetection                  bool
 	ProxyCSV                           string
 	ProxyScoreMultiplier               int
@@ -31,76 +76,179 @@ type Configuration struct {
 	GeoBlockCountriesList              []string
 	GeoBlockCountriesListModeWhitelist bool
 	GeoBlockDuration                   int
+	UseASNDetection                    bool
+	ASNCSV                             string         //IP2Location ASN or MaxMind ASN CSV
+	ASNScoreMultipliers                map[string]int //"AS16509": multiplier
+	ASNBlockList                       []string       //lines from these ASes jail their IP, e.g. "AS64496"
+	ASNBlockDuration                   int
+	FirewallBackend                    string
+	DryRun                             bool //record bans without touching the firewall
+	Sources                            []Source
+	WatchConfigFiles                   bool
+	ControlSocket                      string
+	WebAuthTokens                      []string
+	WebAuthUsers                       map[string]string //user: bcrypt hash
+	WebListen                          string            //"off" disables TCP
+	WebTLSCert                         string
+	WebTLSKey                          string
+	WebSocket                          string
+	Notifiers                          []Notifier
+	SubnetPrefixV4                     int //subnet aggregation prefix lengths, 0 disables
+	SubnetPrefixV6                     int
+	SubnetBanThreshold                 int      //aggregated score that jails a subnet
+	SubnetJailedThreshold              int      //jailed IPs that jail their subnet
+	BanEscalation                      []string //jail time per repeat violation, e.g. "10m", "1h", "1d", "permanent"
+	RepeatDecay                        string   //repeat violations are forgotten after this long, e.g. "30d"
+	PermanentBanFile                   string
+	DNSBLs                             []DNSBL
+	DNSBLServer                        string //"host:port", the system resolver when empty
+	DNSBLCacheTTL                      string //how long lookups are cached, default "1h"
+	Blocklists                         []Blocklist
+	Escalation                         []time.Duration `json:"-"` //parsed BanEscalation
+	RepeatDecayTime                    time.Duration   `json:"-"` //parsed RepeatDecay
+	DNSBLCacheTime                     time.Duration   `json:"-"` //parsed DNSBLCacheTTL
+	ASNMultipliers                     map[int]int     `json:"-"` //parsed ASNScoreMultipliers
+	ASNBlocked                         map[int]bool    `json:"-"` //parsed ASNBlockList
 }
 
-var Data Configuration = Configuration{}
+// DefaultIpRegEx matches an IPv4 or IPv6 address at the beginning of a line
+const DefaultIpRegEx = `^(?:(?:[0-9]{1,3}\.){3}[0-9]{1,3}\b|[0-9a-fA-F:]*:[0-9a-fA-F:.]*)`
+
+// PermanentBan is the escalation step of a ban that never expires
+const PermanentBan time.Duration = -1
+
+// DefaultDNSBLCacheTTL is how long DNSBL lookups are cached by default
+const DefaultDNSBLCacheTTL = time.Hour
+
+// DefaultBlocklistRefresh is how often blocklists are reloaded by default
+const DefaultBlocklistRefresh = 24 * time.Hour
+
+// DefaultControlSocket is where the daemon listens for "ipvoid ctl"
+const DefaultControlSocket = "ipvoid.sock"
+
+var data atomic.Value //*Configuration
 var configFile = "config.json"
 
-func Setup() error {
-	conf, err := os.Open(configFile)
-	if err != nil {
-		return err
-	}
-	decoder := json.NewDecoder(conf)
-	err = decoder.Decode(&Data)
-	if err != nil {
-		return err
-	}
+func init() {
+	data.Store(&Configuration{})
+}
+
+// Get returns the current configuration. A reload replaces it as a whole,
+// so it must not be modified; callers keep reading a consistent one.
+func Get() *Configuration {
+	return data.Load().(*Configuration)
+}
 
-	//init rules
-	Data.Rules = make(map[*regexp.Regexp]int, 10)
+// Set replaces the current configuration
+func Set(conf *Configuration) {
+	data.Store(conf)
+}
 
-	//read rules
-	err = readRules(Data.RulesFile, Data.Rules)
+// Setup reads the configuration and rules files. The configuration is only
+// replaced when everything was read successfully, so Setup can be called
+// again to reload.
+func Setup() error {
+	conf, err := load(configFile)
 	if err != nil {
 		return err
 	}
 
+	Set(conf)
 	return nil
 }
 
-func readRules(path string, rules map[*regexp.Regexp]int) error {
-	file, _ := os.Open(path)
-	reader := bufio.NewReader(file)
-	lineN := 0
-	eof := false
-	for !eof {
-		lineN++
-		bytes, err := reader.ReadBytes('\n')
-		if err != nil && err != io.EOF {
-			return err
+// SetFile sets the configuration file read by Setup
+func SetFile(path string) {
+	configFile = path
+}
+
+// FindSource returns the source of logFile, or the first source when
+// logFile is empty
+func FindSource(logFile string) (*Source, error) {
+	conf := Get()
+	for i := range conf.Sources {
+		if logFile == "" || conf.Sources[i].LogFile == logFile {
+			return &conf.Sources[i], nil
 		}
-		if err == io.EOF {
-			eof = true
-			continue
+	}
+	return nil, errors.New("no source with LogFile " + logFile)
+}
+
+// Files returns the configuration file and the rules files it refers to
+func Files() []string {
+	files := []string{configFile}
+	seen := map[string]bool{configFile: true}
+	for _, src := range Get().Sources {
+		if !seen[src.RulesFile] {
+			seen[src.RulesFile] = true
+			files = append(files, src.RulesFile)
 		}
+	}
+	return files
+}
 
-		line := string(bytes[:len(bytes)-1])
-		i := strings.Index(line, " ")
+// load reads and validates a configuration file. Every problem found is
+// returned in a *ValidationError.
+func load(path string) (*Configuration, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
 
-		if i > -1 {
-			pointsString := line[:i]
-			rule := line[i+1:]
+	conf := &Configuration{}
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	err = decoder.Decode(conf)
+	if err != nil {
+		return nil, jsonError(path, content, err, decoder.InputOffset())
+	}
 
-			points, err := strconv.Atoi(pointsString)
-			if err != nil {
-				log.Println("Rules error: Points is not integer. line:", lineN)
-				return err
-			}
+	//all the unknown settings, not only the first one
+	v := &validator{file: path, content: content}
+	err = checkKeys(v, json.NewDecoder(bytes.NewReader(content)), reflect.TypeOf(conf), "")
+	if err != nil {
+		return nil, jsonError(path, content, err, 0)
+	}
+	if err := v.err(); err != nil {
+		return nil, err
+	}
 
-			r, err := regexp.Compile(rule)
-			if err != nil {
-				log.Println("Rules error: Bad regexp. Line: ", lineN)
-				return err
-			}
+	if conf.IpRegEx == "" {
+		conf.IpRegEx = DefaultIpRegEx
+	}
+	if conf.ControlSocket == "" {
+		conf.ControlSocket = DefaultControlSocket
+	}
 
-			rules[r] = points
+	conf.validate(v)
+	if err := v.err(); err != nil {
+		return nil, err
+	}
+	return conf, nil
+}
 
-		} else {
-			log.Println("Rules error: No delimiter. line:", lineN)
-			return err
-		}
+// Check reads and validates a configuration file without using it
+func Check(path string) error {
+	_, err := load(path)
+	return err
+}
+
+// ParseASN parses an AS number, with or without the "AS" prefix
+func ParseASN(s string) (int, error) {
+	n, err := strconv.Atoi(strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(s)), "AS"))
+	if err != nil || n <= 0 {
+		return 0, errors.New("bad AS number " + s)
 	}
+	return n, nil
+}
 
-	return nil
+// ParseDuration is time.ParseDuration with days ("30d")
+func ParseDuration(s string) (time.Duration, error) {
+	if strings.HasSuffix(s, "d") {
+		days, err := strconv.ParseFloat(strings.TrimSuffix(s, "d"), 64)
+		if err != nil {
+			return 0, err
+		}
+		return time.Duration(days * float64(24*time.Hour)), nil
+	}
+	return time.ParseDuration(s)
 }
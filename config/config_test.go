@@ -2,7 +2,13 @@ package config
 
 import (
 	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
 	"testing"
+	"time"
 )
 
 func init() {
@@ -16,5 +22,255 @@ func TestConf(t *testing.T) {
 		t.Fatalf("Couldn't read config file %s \n", err.Error())
 		return
 	}
-	fmt.Printf("DATA: %v \n", Data)
+	fmt.Printf("DATA: %v \n", Get())
+}
+
+func TestEscalation(t *testing.T) {
+	err := Setup()
+	if err != nil {
+		t.Fatalf("Couldn't read config file %s \n", err.Error())
+	}
+
+	expected := []time.Duration{10 * time.Minute, time.Hour, 24 * time.Hour, PermanentBan}
+	if fmt.Sprint(Get().Escalation) != fmt.Sprint(expected) {
+		t.Fatalf("expected escalation %v, got %v \n", expected, Get().Escalation)
+	}
+	if Get().RepeatDecayTime != 30*24*time.Hour {
+		t.Fatalf("expected a 30 day decay, got %v \n", Get().RepeatDecayTime)
+	}
+}
+
+func TestDefaultIpRegEx(t *testing.T) {
+	r := regexp.MustCompile(DefaultIpRegEx)
+	lines := map[string]string{
+		`1.2.3.4 - - [10/Oct/2020:13:55:36 +0000] "GET / HTTP/1.1" 200`:     "1.2.3.4",
+		`2001:db8::1 - - [10/Oct/2020:13:55:36 +0000] "GET / HTTP/1.1" 200`: "2001:db8::1",
+		`::ffff:1.2.3.4 - - [10/Oct/2020:13:55:36 +0000] "GET / HTTP/1.1"`:  "::ffff:1.2.3.4",
+		`- - [10/Oct/2020:13:55:36 +0000] "GET / HTTP/1.1" 200`:             "",
+	}
+	for line, ip := range lines {
+		if res := r.FindString(line); res != ip {
+			t.Fatalf("expected %q, got %q \n", ip, res)
+		}
+	}
+}
+
+func TestSources(t *testing.T) {
+	configFile = "testsources.json"
+	defer func() { configFile = "testconf.json" }()
+
+	err := Setup()
+	if err != nil {
+		t.Fatalf("Couldn't read config file %s \n", err.Error())
+	}
+
+	if len(Get().Sources) != 2 {
+		t.Fatalf("expected 2 sources, got %d \n", len(Get().Sources))
+	}
+
+	access := Get().Sources[0]
+	if access.IpRegEx != Get().IpRegEx || access.BanThreshold != 100 || len(access.Rules) != len(Get().Rules) {
+		t.Fatalf("access.log did not inherit defaults: %+v \n", access)
+	}
+
+	mail := Get().Sources[1]
+	if mail.BanThreshold != 50 || len(mail.Rules) != 1 {
+		t.Fatalf("mail.log settings not applied: %+v \n", mail)
+	}
+	if mail.Rules[0].Field != "message" || mail.Rules[0].Regex.String() != "SASL LOGIN authentication failed" {
+		t.Fatalf("field rule not parsed: %+v \n", mail.Rules[0])
+	}
+}
+
+func TestYAMLRules(t *testing.T) {
+	rules, err := ReadRules("testrules.yaml")
+	if err != nil {
+		t.Fatalf("Couldn't read rules file %s \n", err.Error())
+	}
+	if len(rules) != 4 {
+		t.Fatalf("expected 4 rules, got %d \n", len(rules))
+	}
+
+	if rules[0].ID != "monitoring" || rules[0].Action != ActionWhitelist {
+		t.Fatalf("unexpected rule: %+v \n", rules[0])
+	}
+	if rules[1].Field != "path" || rules[1].Points != 20 || rules[1].Action != ActionScore {
+		t.Fatalf("unexpected rule: %+v \n", rules[1])
+	}
+	if rules[2].ID != "rule3" || rules[2].Action != ActionBan || rules[2].BanDuration != 1440 || !rules[2].Stop {
+		t.Fatalf("unexpected rule: %+v \n", rules[2])
+	}
+	if rules[3].Count != 5 || rules[3].Window != time.Minute {
+		t.Fatalf("unexpected rate rule: %+v \n", rules[3])
+	}
+
+	_, err = ReadRules("../rules.yaml")
+	if err != nil {
+		t.Fatalf("Couldn't read example rules file %s \n", err.Error())
+	}
+}
+
+func TestReloadKeepsConfigOnError(t *testing.T) {
+	err := Setup()
+	if err != nil {
+		t.Fatalf("Couldn't read config file %s \n", err.Error())
+	}
+	rules := len(Get().Rules)
+
+	configFile = "testbroken.json"
+	defer func() { configFile = "testconf.json" }()
+
+	err = Setup()
+	if err == nil {
+		t.Fatalf("expected error for broken config \n")
+	}
+	if Get().LogFile != "test.log" || len(Get().Rules) != rules {
+		t.Fatalf("configuration changed by a failed reload: %+v \n", Get())
+	}
+}
+
+func TestTextRulesLastLine(t *testing.T) {
+	rules, err := ReadRules("testrules.txt")
+	if err != nil {
+		t.Fatalf("Couldn't read rules file %s \n", err.Error())
+	}
+	if len(rules) != 9 {
+		t.Fatalf("expected 9 rules, got %d \n", len(rules))
+	}
+	if last := rules[len(rules)-1]; last.Regex.String() != `die\(@md5` {
+		t.Fatalf("last line without newline not read: %+v \n", last)
+	}
+}
+
+func TestTextRulesErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ipvoid")
+	if err != nil {
+		t.Fatalf("tempdir: %s \n", err.Error())
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "rules.txt")
+	ioutil.WriteFile(path, []byte("10 ok\nnodelimiter\n# comment\nx bad\n10 (\n10 \n"), 0644)
+
+	_, err = ReadRules(path)
+	verr, ok := err.(*ValidationError)
+	if !ok {
+		t.Fatalf("expected a validation error, got %v \n", err)
+	}
+	expected := []string{path + ":2: ", path + ":4: ", path + ":5: ", path + ":6: "}
+	if len(verr.Problems) != len(expected) {
+		t.Fatalf("expected %d problems, got %q \n", len(expected), verr.Problems)
+	}
+	for i, prefix := range expected {
+		if !strings.HasPrefix(verr.Problems[i], prefix) {
+			t.Fatalf("expected %q to start with %q \n", verr.Problems[i], prefix)
+		}
+	}
+
+	_, err = ReadRules(filepath.Join(dir, "missing.txt"))
+	if err == nil {
+		t.Fatalf("expected an error for a missing rules file \n")
+	}
+}
+
+func TestUnknownField(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ipvoid")
+	if err != nil {
+		t.Fatalf("tempdir: %s \n", err.Error())
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "config.json")
+	ioutil.WriteFile(path, []byte(`{
+    "RulesFile": "testrules.txt",
+    "BanTreshold": 100,
+    "Sources": [{"LogFile": "a.log", "Ruls": "x"}],
+    "Notifiers": [
+        {"Type": "syslog", "Event": ["ban"]}
+    ]
+}
+`), 0644)
+
+	//all of them, nested ones included
+	err = Check(path)
+	expected := path + ":3: unknown setting \"BanTreshold\"\n" +
+		path + ":4: unknown setting \"Ruls\" in Sources\n" +
+		path + ":6: unknown setting \"Event\" in Notifiers"
+	if err == nil || err.Error() != expected {
+		t.Fatalf("unexpected error: %v \n", err)
+	}
+}
+
+func TestProblemLines(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ipvoid")
+	if err != nil {
+		t.Fatalf("tempdir: %s \n", err.Error())
+	}
+	defer os.RemoveAll(dir)
+
+	//ids sharing a prefix, a rule without id and a duplicate id
+	path := filepath.Join(dir, "rules.yaml")
+	ioutil.WriteFile(path, []byte(`rules:
+  - id: ssh-bruteforce
+    match: 'a'
+  - id: ssh
+    match: '('
+  - match: 'a'
+    action: nuke
+  - id: ssh
+    match: 'c'
+  - id: empty
+    match: ''
+  - id: missing
+    points: 10
+`), 0644)
+
+	_, err = ReadRules(path)
+	expected := path + ":4: bad regexp in ssh: error parsing regexp: missing closing ): `(`\n" +
+		path + ":6: unknown action nuke in rule3\n" +
+		path + ":8: duplicate id ssh\n" +
+		path + ":10: no match in empty\n" +
+		path + ":12: no match in missing"
+	if err == nil || err.Error() != expected {
+		t.Fatalf("unexpected error: %v \n", err)
+	}
+
+	//a duplicate is reported where it is
+	path = filepath.Join(dir, "config.json")
+	ioutil.WriteFile(path, []byte(`{
+    "RulesFile": "testrules.txt",
+    "BanThreshold": 100,
+    "Sources": [
+        {"LogFile": "a.log"},
+        {"LogFile": "a.log"}
+    ]
+}
+`), 0644)
+
+	err = Check(path)
+	if err == nil || err.Error() != path+":6: LogFile a.log is watched twice" {
+		t.Fatalf("unexpected error: %v \n", err)
+	}
+}
+
+func TestValidation(t *testing.T) {
+	err := Check("testinvalid.json")
+	verr, ok := err.(*ValidationError)
+	if !ok {
+		t.Fatalf("expected a validation error, got %v \n", err)
+	}
+	expected := []string{"testinvalid.json:4: ", "testinvalid.json:5: ", "testinvalid.json:6: "}
+	if len(verr.Problems) != len(expected) {
+		t.Fatalf("expected %d problems, got %q \n", len(expected), verr.Problems)
+	}
+	for i, prefix := range expected {
+		if !strings.HasPrefix(verr.Problems[i], prefix) {
+			t.Fatalf("expected %q to start with %q \n", verr.Problems[i], prefix)
+		}
+	}
+
+	err = Check("testbroken.json")
+	if err == nil || !strings.HasPrefix(err.Error(), "testbroken.json:4: ") {
+		t.Fatalf("unexpected error: %v \n", err)
+	}
 }
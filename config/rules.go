@@ -0,0 +1,253 @@
+package config
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Rule actions
+const (
+	ActionScore     = "score"     //add points, jail when the score reaches BanThreshold
+	ActionBan       = "ban"       //jail immediately
+	ActionLog       = "log-only"  //log the match, never score
+	ActionWhitelist = "whitelist" //ignore the line, no further rules are applied
+)
+
+// Rule adds Points to an IP when Regex matches its log line, or the parsed
+// Field of the line when Field is set. A rate rule (Count > 0) only applies
+// on the Count-th match of an IP within Window.
+type Rule struct {
+	ID          string
+	Description string
+	Field       string
+	Regex       *regexp.Regexp
+	Points      int
+	BanDuration int //minutes, 0 jails for the score of the IP
+	Action      string
+	Stop        bool //don't apply the rules after this one on a match
+	Count       int
+	Window      time.Duration
+}
+
+// yamlRule is a rule as written in a YAML rules file
+type yamlRule struct {
+	ID          string `yaml:"id"`
+	Description string `yaml:"description"`
+	Field       string `yaml:"field"`
+	Match       string `yaml:"match"`
+	Points      int    `yaml:"points"`
+	BanDuration int    `yaml:"ban_duration"`
+	Action      string `yaml:"action"`
+	Stop        bool   `yaml:"stop"`
+	Count       int    `yaml:"count"`
+	Window      string `yaml:"window"`
+}
+
+type yamlRules struct {
+	Rules []yamlRule `yaml:"rules"`
+}
+
+// ReadRules reads a YAML rules file (.yaml, .yml) or the text format
+func ReadRules(path string) ([]Rule, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return readYAMLRules(path)
+	}
+	return readTextRules(path)
+}
+
+func readYAMLRules(path string) ([]Rule, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var doc yamlRules
+	err = yaml.UnmarshalStrict(content, &doc)
+	if err != nil {
+		//yaml errors already carry their line
+		msg := strings.TrimPrefix(err.Error(), "yaml: ")
+		return nil, &ValidationError{[]string{path + ": " + msg}}
+	}
+
+	v := &validator{file: path, content: content}
+	lines := itemLines(content, "rules")
+	rules := make([]Rule, 0, len(doc.Rules))
+	ids := make(map[string]bool, len(doc.Rules))
+	for i, yr := range doc.Rules {
+		//problems are reported at the first line of the rule
+		line := 0
+		if len(lines) == len(doc.Rules) {
+			line = lines[i]
+		}
+		if yr.ID == "" {
+			yr.ID = fmt.Sprintf("rule%d", i+1)
+		}
+		if ids[yr.ID] {
+			v.addAt(line, "duplicate id %s", yr.ID)
+		}
+		ids[yr.ID] = true
+
+		//an empty regexp matches every line
+		r, err := regexp.Compile(yr.Match)
+		if yr.Match == "" {
+			v.addAt(line, "no match in %s", yr.ID)
+		} else if err != nil {
+			v.addAt(line, "bad regexp in %s: %s", yr.ID, err.Error())
+		}
+
+		if yr.Action == "" {
+			yr.Action = ActionScore
+		}
+		switch yr.Action {
+		case ActionScore, ActionBan, ActionLog, ActionWhitelist:
+		default:
+			v.addAt(line, "unknown action %s in %s", yr.Action, yr.ID)
+		}
+
+		var window time.Duration
+		if yr.Window != "" {
+			window, err = ParseDuration(yr.Window)
+			if err != nil {
+				v.addAt(line, "bad window in %s: %s", yr.ID, err.Error())
+			}
+		}
+		if (yr.Count > 0) != (window > 0) || yr.Count < 0 || window < 0 {
+			v.addAt(line, "%s needs both a positive count and window", yr.ID)
+		}
+
+		rules = append(rules, Rule{
+			ID:          yr.ID,
+			Description: yr.Description,
+			Field:       yr.Field,
+			Regex:       r,
+			Points:      yr.Points,
+			BanDuration: yr.BanDuration,
+			Action:      yr.Action,
+			Stop:        yr.Stop,
+			Count:       yr.Count,
+			Window:      window,
+		})
+	}
+
+	if err := v.err(); err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+// itemLines returns the first lines of the items of the block sequence
+// under the top level key of a YAML document, nil when there is none or it
+// is written in the flow style
+func itemLines(content []byte, key string) []int {
+	var lines []int
+	inside, indent := false, -1
+	for i, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimRight(line, " \r")
+		trimmed := strings.TrimLeft(line, " ")
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		depth := len(line) - len(trimmed)
+		item := trimmed == "-" || strings.HasPrefix(trimmed, "- ")
+
+		//top level keys start and end the sequence, which can be at depth 0
+		if depth == 0 && !item {
+			rest := strings.TrimPrefix(trimmed, key+":")
+			inside = rest != trimmed && (strings.TrimSpace(rest) == "" || strings.HasPrefix(strings.TrimSpace(rest), "#"))
+			continue
+		}
+		if inside && item && (indent == -1 || depth == indent) {
+			indent = depth
+			lines = append(lines, i+1)
+		}
+	}
+	return lines
+}
+
+// readTextRules parses "<points> <regex>" lines. A regex can be applied to a
+// field of a parsed line with "<points> @<field> <regex>". Lines starting
+// with # are comments.
+func readTextRules(path string) ([]Rule, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var problems []string
+	problem := func(lineN int, format string, a ...interface{}) {
+		problems = append(problems, fmt.Sprintf("%s:%d: ", path, lineN)+fmt.Sprintf(format, a...))
+	}
+
+	rules := make([]Rule, 0, 10)
+	scanner := bufio.NewScanner(file)
+	lineN := 0
+	for scanner.Scan() {
+		lineN++
+		line := strings.TrimSuffix(scanner.Text(), "\r")
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		i := strings.Index(line, " ")
+		if i == -1 {
+			problem(lineN, "no delimiter between points and regexp")
+			continue
+		}
+		pointsString := line[:i]
+		rule := line[i+1:]
+
+		field := ""
+		if strings.HasPrefix(rule, "@") {
+			j := strings.Index(rule, " ")
+			if j == -1 {
+				problem(lineN, "no regexp after field")
+				continue
+			}
+			field = rule[1:j]
+			rule = rule[j+1:]
+		}
+
+		points, err := strconv.Atoi(pointsString)
+		if err != nil {
+			problem(lineN, "points %s is not an integer", pointsString)
+			continue
+		}
+
+		if strings.TrimSpace(rule) == "" {
+			problem(lineN, "empty regexp")
+			continue
+		}
+		r, err := regexp.Compile(rule)
+		if err != nil {
+			problem(lineN, "bad regexp: %s", err.Error())
+			continue
+		}
+
+		rules = append(rules, Rule{
+			ID:     fmt.Sprintf("line%d", lineN),
+			Field:  field,
+			Regex:  r,
+			Points: points,
+			Action: ActionScore,
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(problems) > 0 {
+		return nil, &ValidationError{problems}
+	}
+	return rules, nil
+}
@@ -0,0 +1,498 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"ipvoid/parser"
+	"net"
+	"os"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// ValidationError lists every problem found in the configuration and rules
+// files, each prefixed with its file and, when known, its line
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return strings.Join(e.Problems, "\n")
+}
+
+// validator collects the problems of a file
+type validator struct {
+	file     string
+	content  []byte
+	problems []string
+}
+
+// addf adds a problem at the first line of the file containing near
+func (v *validator) addf(near string, format string, a ...interface{}) {
+	v.addNth(near, 1, format, a...)
+}
+
+// addNth adds a problem at the line of the nth occurrence of near
+func (v *validator) addNth(near string, n int, format string, a ...interface{}) {
+	v.addAt(lineOf(v.content, near, n), format, a...)
+}
+
+// addAt adds a problem at line, or at the file when line is 0
+func (v *validator) addAt(line int, format string, a ...interface{}) {
+	loc := v.file
+	if line > 0 {
+		loc = fmt.Sprintf("%s:%d", v.file, line)
+	}
+	v.problems = append(v.problems, loc+": "+fmt.Sprintf(format, a...))
+}
+
+// merge adds the problems of err, an error of another file
+func (v *validator) merge(err error) {
+	if verr, ok := err.(*ValidationError); ok {
+		v.problems = append(v.problems, verr.Problems...)
+		return
+	}
+	v.problems = append(v.problems, err.Error())
+}
+
+func (v *validator) err() error {
+	if len(v.problems) == 0 {
+		return nil
+	}
+	return &ValidationError{v.problems}
+}
+
+// lineOf returns the line of the nth occurrence of s, 0 when there is none
+func lineOf(content []byte, s string, n int) int {
+	if s == "" {
+		return 0
+	}
+	offset := 0
+	for ; n > 0; n-- {
+		i := bytes.Index(content[offset:], []byte(s))
+		if i == -1 {
+			return 0
+		}
+		offset += i + len(s)
+	}
+	return bytes.Count(content[:offset-len(s)], []byte("\n")) + 1
+}
+
+// lineAt returns the line of a byte offset
+func lineAt(content []byte, offset int64) int {
+	if offset > int64(len(content)) {
+		offset = int64(len(content))
+	}
+	return bytes.Count(content[:offset], []byte("\n")) + 1
+}
+
+// jsonError locates a decoding error of the configuration file
+func jsonError(path string, content []byte, err error, offset int64) error {
+	switch e := err.(type) {
+	case *json.SyntaxError:
+		offset = e.Offset
+	case *json.UnmarshalTypeError:
+		offset = e.Offset
+		err = fmt.Errorf("%s must be a JSON %s", e.Field, e.Type.Kind())
+	}
+	return &ValidationError{[]string{fmt.Sprintf("%s:%d: %s", path, lineAt(content, offset), err.Error())}}
+}
+
+// checkKeys reports the keys of the JSON value read next by dec that don't
+// match a field of t, the way encoding/json matches them. in names the
+// setting holding the value.
+func checkKeys(v *validator, dec *json.Decoder, t reflect.Type, in string) error {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	token, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	switch token {
+	case json.Delim('{'):
+		for dec.More() {
+			token, err := dec.Token()
+			if err != nil {
+				return err
+			}
+			name := token.(string)
+
+			switch t.Kind() {
+			case reflect.Struct:
+				f, ok := jsonField(t, name)
+				if !ok {
+					where := ""
+					if in != "" {
+						where = " in " + in
+					}
+					v.addAt(lineAt(v.content, dec.InputOffset()), "unknown setting %q%s", name, where)
+					err = dec.Decode(&json.RawMessage{})
+				} else {
+					err = checkKeys(v, dec, f.Type, f.Name)
+				}
+			case reflect.Map:
+				err = checkKeys(v, dec, t.Elem(), in)
+			default:
+				err = dec.Decode(&json.RawMessage{})
+			}
+			if err != nil {
+				return err
+			}
+		}
+	case json.Delim('['):
+		for dec.More() {
+			if t.Kind() == reflect.Slice || t.Kind() == reflect.Array {
+				err = checkKeys(v, dec, t.Elem(), in)
+			} else {
+				err = dec.Decode(&json.RawMessage{})
+			}
+			if err != nil {
+				return err
+			}
+		}
+	default:
+		return nil
+	}
+
+	//the closing delimiter
+	_, err = dec.Token()
+	return err
+}
+
+// jsonField finds the field of the struct t a JSON key decodes into
+func jsonField(t reflect.Type, name string) (reflect.StructField, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := strings.Split(f.Tag.Get("json"), ",")[0]
+		if tag == "-" || f.PkgPath != "" {
+			continue
+		}
+		if tag == "" {
+			tag = f.Name
+		}
+		if strings.EqualFold(tag, name) {
+			return f, true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+// key is how a setting appears in the configuration file, to find its line
+func key(name string) string {
+	return `"` + name + `"`
+}
+
+func (conf *Configuration) validate(v *validator) {
+	if conf.BanThreshold <= 0 {
+		v.addf(key("BanThreshold"), "BanThreshold must be greater than 0")
+	}
+	if conf.DecreasePerMinute < 0 {
+		v.addf(key("DecreasePerMinute"), "DecreasePerMinute can't be negative")
+	}
+	if _, err := regexp.Compile(conf.IpRegEx); err != nil {
+		v.addf(key("IpRegEx"), "bad IpRegEx: %s", err.Error())
+	}
+
+	for _, cidr := range conf.CIDRWhitelist {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			v.addf(key(cidr), "CIDRWhitelist: %s is not in a CIDR notation", cidr)
+		}
+	}
+
+	if conf.UseProxyDetection {
+		checkFile(v, "ProxyCSV", conf.ProxyCSV)
+		if conf.ProxyScoreMultiplier < 1 {
+			v.addf(key("ProxyScoreMultiplier"), "ProxyScoreMultiplier must be at least 1")
+		}
+		if conf.ProxyCountryScoreMultiplier < 1 {
+			v.addf(key("ProxyCountryScoreMultiplier"), "ProxyCountryScoreMultiplier must be at least 1")
+		}
+		checkCountries(v, "ProxyCountriesList", conf.ProxyCountriesList)
+	}
+	if conf.UseGEODetection {
+		checkFile(v, "GeoBlockCSV", conf.GeoBlockCSV)
+		if conf.GeoBlockDuration <= 0 {
+			v.addf(key("GeoBlockDuration"), "GeoBlockDuration must be greater than 0")
+		}
+		checkCountries(v, "GeoBlockCountriesList", conf.GeoBlockCountriesList)
+	}
+
+	if conf.UseASNDetection {
+		conf.validateASN(v)
+	}
+
+	switch conf.FirewallBackend {
+	case "", "iptables", "ipset", "nftables":
+	default:
+		v.addf(key("FirewallBackend"), "unknown FirewallBackend %s", conf.FirewallBackend)
+	}
+
+	if (conf.WebTLSCert == "") != (conf.WebTLSKey == "") {
+		v.addf(key("WebTLSCert"), "WebTLSCert and WebTLSKey go together")
+	} else if conf.WebTLSCert != "" {
+		checkFile(v, "WebTLSCert", conf.WebTLSCert)
+		checkFile(v, "WebTLSKey", conf.WebTLSKey)
+	}
+	for user, hash := range conf.WebAuthUsers {
+		if !strings.HasPrefix(hash, "$2") {
+			v.addf(key(user), "WebAuthUsers: the password of %s is not a bcrypt hash", user)
+		}
+	}
+
+	for i, n := range conf.Notifiers {
+		conf.validateNotifier(v, i+1, n)
+	}
+
+	if conf.SubnetPrefixV4 < 0 || conf.SubnetPrefixV4 > 32 {
+		v.addf(key("SubnetPrefixV4"), "SubnetPrefixV4 must be between 0 and 32")
+	}
+	if conf.SubnetPrefixV6 < 0 || conf.SubnetPrefixV6 > 128 {
+		v.addf(key("SubnetPrefixV6"), "SubnetPrefixV6 must be between 0 and 128")
+	}
+	if conf.SubnetBanThreshold < 0 || conf.SubnetJailedThreshold < 0 {
+		v.addf(key("SubnetBanThreshold"), "subnet thresholds can't be negative")
+	}
+
+	for _, step := range conf.BanEscalation {
+		d := PermanentBan
+		if step != "permanent" {
+			var err error
+			d, err = ParseDuration(step)
+			if err != nil || d <= 0 {
+				v.addf(key(step), "BanEscalation: bad duration %s", step)
+				continue
+			}
+		}
+		conf.Escalation = append(conf.Escalation, d)
+	}
+	if conf.RepeatDecay != "" {
+		var err error
+		conf.RepeatDecayTime, err = ParseDuration(conf.RepeatDecay)
+		if err != nil || conf.RepeatDecayTime < 0 {
+			v.addf(key("RepeatDecay"), "RepeatDecay: bad duration %s", conf.RepeatDecay)
+		}
+	}
+
+	for _, bl := range conf.DNSBLs {
+		validateDNSBL(v, bl)
+	}
+	if conf.DNSBLServer != "" {
+		if _, _, err := net.SplitHostPort(conf.DNSBLServer); err != nil {
+			v.addf(key("DNSBLServer"), "DNSBLServer: %s", err.Error())
+		}
+	}
+	conf.DNSBLCacheTime = DefaultDNSBLCacheTTL
+	if conf.DNSBLCacheTTL != "" {
+		var err error
+		conf.DNSBLCacheTime, err = ParseDuration(conf.DNSBLCacheTTL)
+		if err != nil || conf.DNSBLCacheTime <= 0 {
+			v.addf(key("DNSBLCacheTTL"), "DNSBLCacheTTL: bad duration %s", conf.DNSBLCacheTTL)
+		}
+	}
+
+	names := make(map[string]int, len(conf.Blocklists))
+	for i := range conf.Blocklists {
+		bl := &conf.Blocklists[i]
+		names[bl.Name]++
+		if names[bl.Name] > 1 {
+			v.addNth(key(bl.Name), names[bl.Name], "Blocklist %s is configured twice", bl.Name)
+		}
+		validateBlocklist(v, bl)
+	}
+
+	//read rules
+	if conf.RulesFile != "" {
+		var err error
+		conf.Rules, err = ReadRules(conf.RulesFile)
+		if err != nil {
+			v.merge(err)
+		}
+	}
+
+	//single LogFile configuration
+	if len(conf.Sources) == 0 {
+		conf.Sources = []Source{{LogFile: conf.LogFile}}
+	}
+
+	seen := make(map[string]int, len(conf.Sources))
+	for i := range conf.Sources {
+		src := &conf.Sources[i]
+		seen[src.LogFile]++
+		if seen[src.LogFile] > 1 {
+			v.addNth(key(src.LogFile), seen[src.LogFile], "LogFile %s is watched twice", src.LogFile)
+		}
+		conf.setupSource(v, src)
+	}
+}
+
+func (conf *Configuration) validateASN(v *validator) {
+	checkFile(v, "ASNCSV", conf.ASNCSV)
+
+	conf.ASNMultipliers = make(map[int]int, len(conf.ASNScoreMultipliers))
+	for as, m := range conf.ASNScoreMultipliers {
+		n, err := ParseASN(as)
+		if err != nil {
+			v.addf(key(as), "ASNScoreMultipliers: %s", err.Error())
+			continue
+		}
+		if m < 1 {
+			v.addf(key(as), "ASNScoreMultipliers: the multiplier of %s must be at least 1", as)
+		}
+		conf.ASNMultipliers[n] = m
+	}
+
+	conf.ASNBlocked = make(map[int]bool, len(conf.ASNBlockList))
+	for _, as := range conf.ASNBlockList {
+		n, err := ParseASN(as)
+		if err != nil {
+			v.addf(key(as), "ASNBlockList: %s", err.Error())
+			continue
+		}
+		conf.ASNBlocked[n] = true
+	}
+	if len(conf.ASNBlockList) > 0 && conf.ASNBlockDuration <= 0 {
+		v.addf(key("ASNBlockDuration"), "ASNBlockDuration must be greater than 0")
+	}
+}
+
+func (conf *Configuration) validateNotifier(v *validator, n int, cfg Notifier) {
+	for _, e := range cfg.Events {
+		switch e {
+		case "ban", "unban", "repeat-offender", "geo-block", "asn-block":
+		default:
+			v.addf(key(e), "notifier %d: unknown event %s", n, e)
+		}
+	}
+
+	switch cfg.Type {
+	case "webhook":
+		if cfg.URL == "" {
+			v.addf(key("Notifiers"), "notifier %d: webhook without URL", n)
+		}
+	case "smtp":
+		if cfg.SMTPServer == "" || cfg.From == "" || len(cfg.To) == 0 {
+			v.addf(key("Notifiers"), "notifier %d: smtp needs SMTPServer, From and To", n)
+		}
+	case "syslog":
+	default:
+		v.addf(key("Notifiers"), "notifier %d: unknown type %s", n, cfg.Type)
+	}
+
+	if (cfg.Retries != nil && *cfg.Retries < 0) || cfg.QueueSize < 0 {
+		v.addf(key("Notifiers"), "notifier %d: Retries and QueueSize can't be negative", n)
+	}
+}
+
+func validateDNSBL(v *validator, bl DNSBL) {
+	if bl.Zone == "" {
+		v.addf(key("DNSBLs"), "DNSBL without Zone")
+		return
+	}
+	if bl.Multiplier < 0 || (bl.Multiplier == 0 && len(bl.Multipliers) == 0) {
+		v.addf(key(bl.Zone), "DNSBL %s: needs a Multiplier or Multipliers of at least 1", bl.Zone)
+	}
+	for code, m := range bl.Multipliers {
+		if net.ParseIP(code) == nil {
+			v.addf(key(code), "DNSBL %s: return code %s is not an IP address", bl.Zone, code)
+		}
+		if m < 1 {
+			v.addf(key(code), "DNSBL %s: the multiplier of %s must be at least 1", bl.Zone, code)
+		}
+	}
+}
+
+var blocklistNameRegEx = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+func validateBlocklist(v *validator, bl *Blocklist) {
+	if !blocklistNameRegEx.MatchString(bl.Name) {
+		v.addf(key("Blocklists"), "Blocklist name %q: letters, digits, '.', '_' and '-' only", bl.Name)
+	}
+	if bl.URL == "" {
+		v.addf(key(bl.Name), "Blocklist %s: URL is not set", bl.Name)
+	} else if !strings.HasPrefix(bl.URL, "http://") && !strings.HasPrefix(bl.URL, "https://") {
+		checkFile(v, "Blocklist "+bl.Name, bl.URL)
+	}
+
+	switch bl.Format {
+	case "", "text":
+	case "csv":
+		if bl.Column < 0 {
+			v.addf(key(bl.Name), "Blocklist %s: Column starts at 1", bl.Name)
+		}
+	default:
+		v.addf(key(bl.Name), "Blocklist %s: unknown Format %s", bl.Name, bl.Format)
+	}
+
+	bl.RefreshTime = DefaultBlocklistRefresh
+	if bl.Refresh != "" {
+		var err error
+		bl.RefreshTime, err = ParseDuration(bl.Refresh)
+		if err != nil || bl.RefreshTime < time.Minute {
+			v.addf(key(bl.Refresh), "Blocklist %s: Refresh must be a duration of at least 1m", bl.Name)
+		}
+	}
+}
+
+func checkFile(v *validator, name string, path string) {
+	if path == "" {
+		v.addf(key(name), "%s is not set", name)
+		return
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		v.addf(key(name), "%s: %s", name, err.Error())
+		return
+	}
+	file.Close()
+}
+
+var countryRegEx = regexp.MustCompile(`^[A-Z]{2}$`)
+
+func checkCountries(v *validator, name string, countries []string) {
+	for _, c := range countries {
+		if !countryRegEx.MatchString(c) {
+			v.addf(key(c), "%s: %s is not a two letter country code", name, c)
+		}
+	}
+}
+
+func (conf *Configuration) setupSource(v *validator, src *Source) {
+	if src.LogFile == "" {
+		v.addf(key("LogFile"), "Source error: LogFile is not set")
+		return
+	}
+	if src.IpRegEx == "" {
+		src.IpRegEx = conf.IpRegEx
+	} else if _, err := regexp.Compile(src.IpRegEx); err != nil {
+		v.addf(key(src.LogFile), "%s: bad IpRegEx: %s", src.LogFile, err.Error())
+	}
+	if src.BanThreshold == 0 {
+		src.BanThreshold = conf.BanThreshold
+	} else if src.BanThreshold < 0 {
+		v.addf(key(src.LogFile), "%s: BanThreshold must be greater than 0", src.LogFile)
+	}
+	if _, err := parser.New(src.Format, src.IpField); err != nil {
+		v.addf(key(src.LogFile), "%s: %s", src.LogFile, err.Error())
+	}
+
+	if src.RulesFile == "" || src.RulesFile == conf.RulesFile {
+		if conf.RulesFile == "" {
+			v.addf(key(src.LogFile), "Source error: no RulesFile for %s", src.LogFile)
+			return
+		}
+		src.RulesFile = conf.RulesFile
+		src.Rules = conf.Rules
+		return
+	}
+
+	var err error
+	src.Rules, err = ReadRules(src.RulesFile)
+	if err != nil {
+		v.merge(err)
+	}
+}
@@ -0,0 +1,67 @@
+package ctl
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"ipvoid/config"
+	"net"
+	"os"
+	"time"
+)
+
+// Main runs "ipvoid ctl [-socket path] <command>" and returns the exit code
+func Main(args []string) int {
+	socket := config.DefaultControlSocket
+	if config.Setup() == nil {
+		socket = config.Get().ControlSocket
+	}
+
+	flags := flag.NewFlagSet("ctl", flag.ContinueOnError)
+	flags.StringVar(&socket, "socket", socket, "control socket of the daemon")
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: ipvoid ctl [-socket path] <command>\n%s", usage)
+	}
+	if flags.Parse(args) != nil {
+		return 2
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return 2
+	}
+
+	res, err := Send(socket, flags.Args())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ipvoid ctl: %s\n", err.Error())
+		return 1
+	}
+
+	fmt.Print(res.Output)
+	if res.Error != "" {
+		fmt.Fprintln(os.Stderr, res.Error)
+		return 1
+	}
+	return 0
+}
+
+// Send runs a command on the daemon listening on socket
+func Send(socket string, args []string) (*Response, error) {
+	conn, err := net.DialTimeout("unix", socket, 5*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(30 * time.Second))
+
+	err = json.NewEncoder(conn).Encode(Request{args})
+	if err != nil {
+		return nil, err
+	}
+
+	res := &Response{}
+	err = json.NewDecoder(conn).Decode(res)
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
@@ -0,0 +1,230 @@
+package ctl
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"ipvoid/jail"
+	"ipvoid/resolver"
+	"ipvoid/watch"
+	"log"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Request is a command sent by "ipvoid ctl" to the daemon
+type Request struct {
+	Args []string
+}
+
+// Response is the result of a Request
+type Response struct {
+	Output string
+	Error  string
+}
+
+const usage = `commands:
+  ban <ip> <minutes>        jail an IP
+  unban <ip|cidr>           release an IP and reset its score, or a subnet
+  status <ip>               show score, jail time and repeat violations
+  jail                      list jailed IPs
+  whitelist                 list whitelisted networks
+  whitelist add <cidr>      add a network to the whitelist, kept on reload
+  whitelist remove <cidr>   remove a network from the whitelist (configured
+                            networks are back on reload)
+`
+
+var listener net.Listener
+
+// Serve accepts commands on the Unix socket at path
+func Serve(path string) error {
+	//remove a socket left by a previous run
+	os.Remove(path)
+
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return err
+	}
+	err = os.Chmod(path, 0600)
+	if err != nil {
+		l.Close()
+		return err
+	}
+	listener = l
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go handle(conn)
+	}
+}
+
+// Close stops accepting commands and removes the socket
+func Close() {
+	if listener != nil {
+		listener.Close()
+	}
+}
+
+func handle(conn net.Conn) {
+	defer conn.Close()
+
+	req := Request{}
+	err := json.NewDecoder(conn).Decode(&req)
+	if err != nil {
+		log.Println("ctl: bad request: " + err.Error())
+		return
+	}
+
+	res := Response{}
+	res.Output, err = execute(req.Args)
+	if err != nil {
+		res.Error = err.Error()
+	}
+	json.NewEncoder(conn).Encode(res)
+}
+
+func execute(args []string) (string, error) {
+	if len(args) == 0 {
+		return "", errors.New("no command\n" + usage)
+	}
+
+	switch args[0] {
+	case "ban":
+		if len(args) != 3 {
+			return "", errors.New("usage: ban <ip> <minutes>")
+		}
+		minutes, err := strconv.ParseFloat(args[2], 32)
+		if err != nil || minutes <= 0 {
+			return "", errors.New("minutes must be a positive number")
+		}
+		err = jail.BlockIPFor(args[1], float32(minutes))
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%s jailed for %s minutes\n", args[1], args[2]), nil
+
+	case "unban":
+		if len(args) != 2 {
+			return "", errors.New("usage: unban <ip>")
+		}
+		if _, ipnet, err := net.ParseCIDR(args[1]); err == nil {
+			err = jail.UnblockIP(ipnet.String())
+			if err != nil {
+				return "", err
+			}
+			return ipnet.String() + " released\n", nil
+		}
+		ip := normalize(args[1])
+		if ip == "" {
+			return "", errors.New("Parameter is not an IP")
+		}
+		//an IP that only has a score is released from the watchlist alone
+		if jail.Jailed(ip) || watch.Score(ip) == 0 {
+			err := jail.UnblockIP(ip)
+			if err != nil {
+				return "", err
+			}
+		}
+		watch.Forget(ip)
+		return ip + " released\n", nil
+
+	case "status":
+		if len(args) != 2 {
+			return "", errors.New("usage: status <ip>")
+		}
+		ip := normalize(args[1])
+		if ip == "" {
+			return "", errors.New("Parameter is not an IP")
+		}
+		return status(ip), nil
+
+	case "jail":
+		return jailList(), nil
+
+	case "whitelist":
+		return whitelist(args[1:])
+
+	case "help":
+		return usage, nil
+	}
+
+	return "", errors.New("unknown command: " + args[0] + "\n" + usage)
+}
+
+func normalize(ip string) string {
+	res := net.ParseIP(ip)
+	if res == nil {
+		return ""
+	}
+	return res.String()
+}
+
+func status(ip string) string {
+	points, jailed, repeat := jail.IPStatus(ip)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "IP:                %s\n", ip)
+	fmt.Fprintf(&b, "Host:              %s\n", strings.TrimSpace(resolver.Lookup(ip)))
+	fmt.Fprintf(&b, "Score:             %.2f\n", watch.Score(ip))
+	if jailed {
+		fmt.Fprintf(&b, "Jailed:            %.0f minutes left\n", points)
+	} else {
+		fmt.Fprintf(&b, "Jailed:            no\n")
+	}
+	fmt.Fprintf(&b, "Repeat violations: %d\n", repeat)
+	return b.String()
+}
+
+func jailList() string {
+	type entry struct {
+		ip     string
+		points float32
+	}
+
+	var entries []entry
+	for ip, points := range jail.Jail() {
+		entries = append(entries, entry{ip, points})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].points > entries[j].points
+	})
+
+	var b strings.Builder
+	for _, e := range entries {
+		_, _, repeat := jail.IPStatus(e.ip)
+		fmt.Fprintf(&b, "%8.0f min  x%-3d %s\n", e.points, repeat, e.ip)
+	}
+	fmt.Fprintf(&b, "%d IPs jailed\n", len(entries))
+	return b.String()
+}
+
+func whitelist(args []string) (string, error) {
+	if len(args) == 0 {
+		return strings.Join(jail.Whitelist(), "\n") + "\n", nil
+	}
+	if len(args) != 2 {
+		return "", errors.New("usage: whitelist [add|remove <cidr>]")
+	}
+
+	switch args[0] {
+	case "add":
+		if _, _, err := net.ParseCIDR(args[1]); err != nil {
+			return "", errors.New("parameter is not in a CIDR notation")
+		}
+		jail.AppendWhitelist(args[1])
+		return args[1] + " added to the whitelist\n", nil
+	case "remove":
+		err := jail.RemoveWhitelist(args[1])
+		if err != nil {
+			return "", err
+		}
+		return args[1] + " removed from the whitelist\n", nil
+	}
+	return "", errors.New("usage: whitelist [add|remove <cidr>]")
+}
@@ -0,0 +1,117 @@
+package ctl
+
+import (
+	"errors"
+	"io/ioutil"
+	"ipvoid/jail"
+	"ipvoid/watch"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+type mockFireWall struct {
+	banned    map[string]time.Duration
+	failUnban bool
+}
+
+func (mf *mockFireWall) Init() error  { return nil }
+func (mf *mockFireWall) Clear() error { return nil }
+func (mf *mockFireWall) Ban(ip string, timeout time.Duration) error {
+	mf.banned[ip] = timeout
+	return nil
+}
+func (mf *mockFireWall) Unban(ip string) error {
+	if mf.failUnban {
+		return errors.New("unban failed")
+	}
+	delete(mf.banned, ip)
+	return nil
+}
+
+func TestCtl(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ctl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	mf := &mockFireWall{banned: make(map[string]time.Duration)}
+	jail.Setup(mf)
+
+	socket := filepath.Join(dir, "ipvoid.sock")
+	go Serve(socket)
+	defer Close()
+	time.Sleep(100 * time.Millisecond)
+
+	run := func(args ...string) *Response {
+		res, err := Send(socket, args)
+		if err != nil {
+			t.Fatalf("Send failed: %s \n", err.Error())
+		}
+		return res
+	}
+
+	res := run("ban", "2001:DB8::1", "30")
+	if res.Error != "" {
+		t.Fatalf("ban failed: %s \n", res.Error)
+	}
+	if mf.banned["2001:db8::1"] != 31*time.Minute {
+		t.Fatalf("expected ban in the firewall, got %v \n", mf.banned)
+	}
+
+	res = run("status", "2001:db8::1")
+	if !strings.Contains(res.Output, "30 minutes left") || !strings.Contains(res.Output, "Repeat violations: 0") {
+		t.Fatalf("unexpected status: %s \n", res.Output)
+	}
+
+	res = run("jail")
+	if !strings.Contains(res.Output, "2001:db8::1") {
+		t.Fatalf("unexpected jail: %s \n", res.Output)
+	}
+
+	res = run("unban", "2001:db8::1")
+	if res.Error != "" || len(mf.banned) != 0 {
+		t.Fatalf("unban failed: %s %v \n", res.Error, mf.banned)
+	}
+
+	//a failed unban keeps the IP jailed and its score
+	run("ban", "192.0.2.77", "30")
+	prev := watch.Watchlist
+	watch.Watchlist = map[string]float32{"192.0.2.77": 50}
+	defer func() { watch.Watchlist = prev }()
+	mf.failUnban = true
+	res = run("unban", "192.0.2.77")
+	mf.failUnban = false
+	if res.Error == "" || !jail.Jailed("192.0.2.77") || watch.Score("192.0.2.77") != 50 {
+		t.Fatalf("failed unban reported as released: %q %q \n", res.Output, res.Error)
+	}
+	res = run("unban", "192.0.2.77")
+	if res.Error != "" || jail.Jailed("192.0.2.77") || watch.Score("192.0.2.77") != 0 {
+		t.Fatalf("unban failed: %s \n", res.Error)
+	}
+
+	res = run("whitelist", "add", "10.1.0.0/16")
+	if res.Error != "" {
+		t.Fatalf("whitelist add failed: %s \n", res.Error)
+	}
+	res = run("ban", "10.1.2.3", "30")
+	if res.Error == "" {
+		t.Fatalf("expected whitelisted IP not to be jailed \n")
+	}
+	res = run("whitelist", "remove", "10.1.0.0/16")
+	if res.Error != "" || strings.Contains(run("whitelist").Output, "10.1.0.0/16") {
+		t.Fatalf("whitelist remove failed: %s \n", res.Error)
+	}
+
+	res = run("ban", "not-an-ip", "30")
+	if res.Error == "" {
+		t.Fatalf("expected error for invalid IP \n")
+	}
+	res = run("frobnicate")
+	if res.Error == "" {
+		t.Fatalf("expected error for unknown command \n")
+	}
+}
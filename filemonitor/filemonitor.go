@@ -6,6 +6,8 @@ import (
 	"io"
 	"log"
 	"os"
+	"path/filepath"
+	"strings"
 	"sync"
 	"syscall"
 	"time"
@@ -208,6 +210,73 @@ func (fm *FileMonitor) tailLoop(path string, fchan *FileChan) {
 	}
 }
 
+// WatchChanges notifies on Cout with the path whenever the file is written or
+// replaced (e.g. by an editor saving it with a rename). The parent directory
+// is watched, so the file doesn't need to exist all the time.
+func (fm *FileMonitor) WatchChanges(path string) (*FileChan, error) {
+	fd, err := syscall.InotifyInit()
+	if err != nil {
+		return nil, err
+	}
+
+	dir, name := filepath.Split(path)
+	if dir == "" {
+		dir = "."
+	}
+
+	_, err = syscall.InotifyAddWatch(fd, dir, syscall.IN_CLOSE_WRITE|syscall.IN_MOVED_TO|syscall.IN_CREATE)
+	if err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
+
+	fchan := &FileChan{}
+	fchan.Cout = make(chan string, 10)
+	fchan.Cerr = make(chan string)
+
+	go changeLoop(fd, path, name, fchan)
+
+	return fchan, nil
+}
+
+func changeLoop(fd int, path string, name string, fchan *FileChan) {
+	var buffer [(syscall.SizeofInotifyEvent + syscall.NAME_MAX + 1) * 100]byte
+	defer syscall.Close(fd)
+
+	for {
+		len, err := syscall.Read(fd, buffer[0:])
+		if err != nil || len == -1 {
+			log.Println("Inotify Read error in ", path)
+			fchan.Cerr <- "Watcher aborted. Inotify Read error."
+			return
+		}
+
+		changed := false
+		var offset = 0
+		for offset+syscall.SizeofInotifyEvent <= len {
+			raw := (*syscall.InotifyEvent)(unsafe.Pointer(&buffer[offset]))
+			nameBytes := buffer[offset+syscall.SizeofInotifyEvent : offset+syscall.SizeofInotifyEvent+int(raw.Len)]
+			offset = offset + int(raw.Len) + syscall.SizeofInotifyEvent
+
+			if raw.Mask&syscall.IN_IGNORED == syscall.IN_IGNORED {
+				fchan.Cerr <- "Watcher closed. (IN_IGNORED)"
+				return
+			}
+			if strings.TrimRight(string(nameBytes), "\x00") == name {
+				changed = true
+			}
+		}
+
+		//one notification per batch of events
+		if changed {
+			select {
+			case fchan.Cout <- path:
+			default:
+			}
+		}
+	}
+}
+
 func readLoop(reader *bufio.Reader, cout chan<- string) error {
 	eof := false
 	for !eof {
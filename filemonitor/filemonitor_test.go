@@ -0,0 +1,55 @@
+package filemonitor
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWatchChanges(t *testing.T) {
+	dir, err := ioutil.TempDir("", "filemonitor")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "rules.txt")
+	ioutil.WriteFile(path, []byte("10 a\n"), 0644)
+
+	fm := NewFileMonitor()
+	fc, err := fm.WatchChanges(path)
+	if err != nil {
+		t.Fatalf("WatchChanges failed: %s \n", err.Error())
+	}
+
+	expectChange := func(what string) {
+		select {
+		case p := <-fc.Cout:
+			if p != path {
+				t.Fatalf("%s: unexpected path %s \n", what, p)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("%s: no change notification \n", what)
+		}
+	}
+
+	//in place write
+	ioutil.WriteFile(path, []byte("20 a\n"), 0644)
+	expectChange("write")
+
+	//editor style replace
+	tmp := filepath.Join(dir, ".rules.txt.swp")
+	ioutil.WriteFile(tmp, []byte("30 a\n"), 0644)
+	os.Rename(tmp, path)
+	expectChange("rename")
+
+	//other files in the directory are ignored
+	ioutil.WriteFile(filepath.Join(dir, "other.txt"), []byte("x"), 0644)
+	select {
+	case <-fc.Cout:
+		t.Fatalf("unexpected notification for another file \n")
+	case <-time.After(200 * time.Millisecond):
+	}
+}
@@ -2,4 +2,8 @@ module ipvoid
 
 go 1.14
 
-require github.com/coreos/go-iptables v0.4.5
+require (
+	github.com/coreos/go-iptables v0.4.5
+	golang.org/x/crypto v0.0.0-20220525230936-793ad666bf5e
+	gopkg.in/yaml.v2 v2.4.0
+)
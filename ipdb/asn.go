@@ -0,0 +1,97 @@
+package ipdb
+
+import (
+	"encoding/csv"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// CreateASN loads an ASN database. Two CSV layouts are read:
+//
+//	"ip_from","ip_to","cidr","asn","as"           (IP2Location ASN)
+//	network,autonomous_system_number,autonomous_system_organization  (MaxMind)
+//
+// A header line and ranges without an AS ("-") are skipped.
+func CreateASN(path string) (error, *IPDataBase) {
+	ips := &IPDataBase{Loaded: false}
+
+	ips.ipStartArray = make([]ipKey, 0)
+	ips.ipRangeMap = make(map[ipKey]IPRange)
+
+	file, err := os.Open(path)
+	if err != nil {
+		return err, ips
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+	for line := 1; ; line++ {
+		elements, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return errors.New("IPDB: " + err.Error()), ips
+		}
+
+		var start, end ipKey
+		var asn, org string
+		switch {
+		case len(elements) >= 3 && strings.Contains(elements[0], "/"):
+			start, end, err = cidrRange(elements[0])
+			asn, org = elements[1], elements[2]
+		case len(elements) >= 5:
+			start, end, err = parseRange(elements[0], elements[1])
+			asn, org = elements[3], elements[4]
+		default:
+			err = errors.New("IPDB: not enough columns in line " + strconv.Itoa(line))
+		}
+
+		if err != nil {
+			//header
+			if line == 1 {
+				continue
+			}
+			return err, ips
+		}
+		if asn == "-" || asn == "" {
+			continue
+		}
+
+		number, err := strconv.Atoi(strings.TrimPrefix(strings.ToUpper(asn), "AS"))
+		if err != nil {
+			return errors.New("IPDB: bad AS number in line " + strconv.Itoa(line) + ": " + asn), ips
+		}
+
+		ips.ipStartArray = append(ips.ipStartArray, start)
+		ips.ipRangeMap[start] = IPRange{ipStart: start, ipEnd: end, ASN: number, ASOrg: org}
+	}
+
+	ips.sort()
+	return nil, ips
+}
+
+// cidrRange returns the first and last address of a CIDR
+func cidrRange(cidr string) (ipKey, ipKey, error) {
+	_, ipnet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return ipKey{}, ipKey{}, errors.New("IPDB: bad network: " + cidr)
+	}
+
+	var start, end ipKey
+	copy(start[:], ipnet.IP.To16())
+	mask := ipnet.Mask
+	if len(mask) == net.IPv4len {
+		//IPv4-mapped, the first 12 bytes are the prefix
+		mask = append(net.CIDRMask(96, 128)[:12], mask...)
+	}
+	for i := range start {
+		end[i] = start[i] | ^mask[i]
+	}
+	return start, end, nil
+}
@@ -3,33 +3,42 @@ package ipdb
 import (
 	"bufio"
 	"bytes"
-	"encoding/binary"
 	"errors"
+	"math/big"
 	"net"
 	"os"
 	"sort"
-	"strconv"
 	"strings"
 )
 
+// ipKey is a 128bit big endian representation of an IP address.
+// IPv4 addresses are stored in their IPv4-mapped IPv6 form (::ffff:a.b.c.d)
+type ipKey [16]byte
+
 type IPRange struct {
-	ipStart    int
-	ipEnd      int
+	ipStart    ipKey
+	ipEnd      ipKey
 	CoutryCode string
 	CoutryName string
+	ASN        int    //set by ASN databases
+	ASOrg      string //organisation of the AS
 }
 
 type IPDataBase struct {
-	ipStartArray []int
-	ipRangeMap   map[int]IPRange
+	ipStartArray []ipKey
+	ipRangeMap   map[ipKey]IPRange
 	Loaded       bool
 }
 
+// v4 ranges are converted to IPv4-mapped IPv6 addresses
+var v4Max = big.NewInt(0xFFFFFFFF)
+var v4Mapped = new(big.Int).Lsh(big.NewInt(0xFFFF), 32)
+
 func Create(path string) (error, *IPDataBase) {
 	ips := &IPDataBase{Loaded: false}
 
-	ips.ipStartArray = make([]int, 0)
-	ips.ipRangeMap = make(map[int]IPRange)
+	ips.ipStartArray = make([]ipKey, 0)
+	ips.ipRangeMap = make(map[ipKey]IPRange)
 
 	file, err := os.Open(path)
 	if err != nil {
@@ -44,45 +53,79 @@ func Create(path string) (error, *IPDataBase) {
 
 		line := scanner.Text()
 		elements := strings.Split(line, ",")
+		if len(elements) < 4 {
+			return errors.New("IPDB: not enough columns in line: " + line), ips
+		}
 
 		for i, e := range elements {
 			elements[i] = strings.Trim(e, "\"")
 		}
 
-		ipStart, err := strconv.Atoi(elements[0])
-		if err != nil {
-			return err, ips
-		}
-
-		ipEnd, err := strconv.Atoi(elements[1])
+		start, end, err := parseRange(elements[0], elements[1])
 		if err != nil {
 			return err, ips
 		}
 
 		//TODO validate all values
-		ips.ipStartArray = append(ips.ipStartArray, ipStart)
-		ips.ipRangeMap[ipStart] = IPRange{ipStart, ipEnd, elements[2], elements[3]}
+		ips.ipStartArray = append(ips.ipStartArray, start)
+		ips.ipRangeMap[start] = IPRange{ipStart: start, ipEnd: end, CoutryCode: elements[2], CoutryName: elements[3]}
 
 	}
 
-	sort.Ints(ips.ipStartArray)
-	ips.Loaded = true
+	ips.sort()
 	return nil, ips
 
 }
 
+// parseRange parses the decimal start and end of a range. IPv4 ranges are
+// converted to IPv4-mapped IPv6 addresses.
+func parseRange(from string, to string) (ipKey, ipKey, error) {
+	ipStart, ok := new(big.Int).SetString(from, 10)
+	if !ok || ipStart.Sign() < 0 {
+		return ipKey{}, ipKey{}, errors.New("IPDB: bad range start: " + from)
+	}
+
+	ipEnd, ok := new(big.Int).SetString(to, 10)
+	if !ok || ipEnd.Sign() < 0 {
+		return ipKey{}, ipKey{}, errors.New("IPDB: bad range end: " + to)
+	}
+
+	//plain IPv4 database
+	if ipStart.Cmp(v4Max) <= 0 && ipEnd.Cmp(v4Max) <= 0 {
+		ipStart.Or(ipStart, v4Mapped)
+		ipEnd.Or(ipEnd, v4Mapped)
+	}
+
+	if ipStart.BitLen() > 128 || ipEnd.BitLen() > 128 {
+		return ipKey{}, ipKey{}, errors.New("IPDB: range is out of IPv6 space: " + from + "-" + to)
+	}
+
+	return int2Key(ipStart), int2Key(ipEnd), nil
+}
+
+// sort orders the ranges for CheckIP and marks the database as loaded
+func (ips *IPDataBase) sort() {
+	sort.Slice(ips.ipStartArray, func(i, j int) bool {
+		return bytes.Compare(ips.ipStartArray[i][:], ips.ipStartArray[j][:]) < 0
+	})
+	ips.Loaded = true
+}
+
 func (ips *IPDataBase) CheckIP(ip string) (error, *IPRange) {
-	err, ipInt := ip2Int(ip)
+	err, key := ip2Key(ip)
 
 	if err != nil {
 		return err, nil
 	}
 
-	offset := ips.findOffset(ipInt)
+	if len(ips.ipStartArray) == 0 {
+		return nil, nil
+	}
+
+	offset := ips.findOffset(key)
+	ipRange := ips.ipRangeMap[offset]
 
-	if (ips.ipRangeMap[offset].ipStart <= ipInt) && (ips.ipRangeMap[offset].ipEnd >= ipInt) {
-		//log.Printf("PROXY: %+v \n", ips.ipRangeMap[offset])
-		ipRange := ips.ipRangeMap[offset]
+	if bytes.Compare(ipRange.ipStart[:], key[:]) <= 0 && bytes.Compare(ipRange.ipEnd[:], key[:]) >= 0 {
 		return nil, &ipRange
 	}
 
@@ -90,8 +133,10 @@ func (ips *IPDataBase) CheckIP(ip string) (error, *IPRange) {
 
 }
 
-func (ips *IPDataBase) findOffset(ipInt int) int {
-	index := sort.SearchInts(ips.ipStartArray, ipInt)
+func (ips *IPDataBase) findOffset(key ipKey) ipKey {
+	index := sort.Search(len(ips.ipStartArray), func(i int) bool {
+		return bytes.Compare(ips.ipStartArray[i][:], key[:]) >= 0
+	})
 
 	if len(ips.ipStartArray) == index {
 		return ips.ipStartArray[index-1]
@@ -101,7 +146,7 @@ func (ips *IPDataBase) findOffset(ipInt int) int {
 		return ips.ipStartArray[index]
 	}
 
-	if ips.ipStartArray[index] == ipInt {
+	if ips.ipStartArray[index] == key {
 		return ips.ipStartArray[index]
 	} else {
 		return ips.ipStartArray[index-1]
@@ -109,12 +154,19 @@ func (ips *IPDataBase) findOffset(ipInt int) int {
 
 }
 
-func ip2Int(ip string) (error, int) {
+func ip2Key(ip string) (error, ipKey) {
+	var key ipKey
 	res := net.ParseIP(ip)
 	if res == nil {
-		return errors.New("Parameter is not an IP"), 0
+		return errors.New("Parameter is not an IP"), key
 	}
-	var long uint32
-	binary.Read(bytes.NewBuffer(net.ParseIP(ip).To4()), binary.BigEndian, &long)
-	return nil, int(long)
+	copy(key[:], res.To16())
+	return nil, key
+}
+
+func int2Key(i *big.Int) ipKey {
+	var key ipKey
+	b := i.Bytes()
+	copy(key[len(key)-len(b):], b)
+	return key
 }
@@ -0,0 +1,81 @@
+package ipdb
+
+import (
+	"testing"
+)
+
+func TestCheckIP(t *testing.T) {
+	err, db := Create("testipdb.csv")
+	if err != nil {
+		t.Fatalf("Couldn't read ipdb file %s \n", err.Error())
+	}
+
+	tests := map[string]string{
+		"1.0.0.1":              "AU",
+		"1.0.2.255":            "CN",
+		"224.0.0.255":          "AU",
+		"::ffff:1.0.0.1":       "AU",
+		"2001:200::1":          "US",
+		"2001:4860:4860::8888": "DE",
+		"1.0.4.1":              "",
+		"8.8.8.8":              "",
+		"2001:db8::1":          "",
+		"::1":                  "",
+	}
+
+	for ip, code := range tests {
+		err, ipRange := db.CheckIP(ip)
+		if err != nil {
+			t.Fatalf("CheckIP(%s) error: %s \n", ip, err.Error())
+		}
+		if code == "" {
+			if ipRange != nil {
+				t.Fatalf("CheckIP(%s): expected no range, got %s \n", ip, ipRange.CoutryCode)
+			}
+			continue
+		}
+		if ipRange == nil || ipRange.CoutryCode != code {
+			t.Fatalf("CheckIP(%s): expected %s, got %v \n", ip, code, ipRange)
+		}
+	}
+
+	err, _ = db.CheckIP("not an ip")
+	if err == nil {
+		t.Fatalf("expected error for invalid IP \n")
+	}
+}
+
+func TestASN(t *testing.T) {
+	for _, path := range []string{"testasn.csv", "testasn_maxmind.csv"} {
+		err, db := CreateASN(path)
+		if err != nil {
+			t.Fatalf("Couldn't read ASN file %s: %s \n", path, err.Error())
+		}
+
+		_, ipRange := db.CheckIP("1.0.0.200")
+		if ipRange == nil || ipRange.ASN != 13335 {
+			t.Fatalf("%s: expected AS13335 for 1.0.0.200, got %v \n", path, ipRange)
+		}
+		_, ipRange = db.CheckIP("1.0.1.1")
+		if ipRange != nil {
+			t.Fatalf("%s: expected no AS for 1.0.1.1, got %v \n", path, ipRange)
+		}
+	}
+
+	err, db := CreateASN("testasn_maxmind.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := map[string]string{
+		"203.0.113.127": "Example, Hosting Ltd",
+		"203.0.113.128": "",
+		"2001:db8::1":   "DOCUMENTATION",
+		"2001:db9::1":   "",
+	}
+	for ip, org := range tests {
+		_, ipRange := db.CheckIP(ip)
+		if (ipRange == nil && org != "") || (ipRange != nil && ipRange.ASOrg != org) {
+			t.Fatalf("CheckIP(%s): expected %q, got %v \n", ip, org, ipRange)
+		}
+	}
+}
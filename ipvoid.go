@@ -1,68 +1,117 @@
 package main
 
 import (
+	"errors"
 	"github.com/coreos/go-iptables/iptables"
+	"ipvoid/blocklist"
+	"ipvoid/checkconfig"
 	"ipvoid/config"
-	"ipvoid/ipdb"
+	"ipvoid/ctl"
 	"ipvoid/jail"
+	"ipvoid/notify"
+	"ipvoid/replay"
+	"ipvoid/testrules"
 	"ipvoid/watch"
 	"ipvoid/web"
 	"log"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 )
 
 func main() {
 
-	err := config.Setup()
-	if err != nil {
-		log.Println("Config error: %s \n", err.Error())
-		os.Exit(1)
+	//subcommands
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "ctl":
+			os.Exit(ctl.Main(os.Args[2:]))
+		case "replay":
+			os.Exit(replay.Main(os.Args[2:]))
+		case "test-rules":
+			os.Exit(testrules.Main(os.Args[2:]))
+		case "check-config":
+			os.Exit(checkconfig.Main(os.Args[2:]))
+		default:
+			log.Printf("Unknown command: %s \n", os.Args[1])
+			os.Exit(2)
+		}
 	}
 
-	//Init IP Tables interface
-	ipt, err := iptables.New()
+	//refuse to start on an invalid configuration
+	err := config.Setup()
 	if err != nil {
-		log.Printf("IPtables init issue: %s \n", err.Error())
+		for _, p := range checkconfig.Problems(err) {
+			log.Printf("Config error: %s \n", p)
+		}
 		os.Exit(1)
 	}
 
-	err = jail.Setup(ipt)
+	conf := config.Get()
+	err = notify.Setup(conf.Notifiers)
 	if err != nil {
-		log.Printf("IPtables setup issue: %s \n", err.Error())
+		log.Printf("Notifier issue: %s \n", err.Error())
 		os.Exit(1)
 	}
 
-	if config.Data.UseProxyDetection {
-		err, ipProxy := ipdb.Create(config.Data.ProxyCSV)
+	var firewall jail.Firewall = jail.DryRunFirewall{}
+	if conf.DryRun {
+		log.Println("Dry run: bans are recorded, the firewall is not touched")
+	} else {
+		firewall, err = newFirewall(conf.FirewallBackend)
 		if err != nil {
-			log.Printf("IPDB read issue: %s \n", err.Error())
+			log.Printf("Firewall init issue: %s \n", err.Error())
 			os.Exit(1)
 		}
-
-		//add loaded proxy checker to watcher system
-		watch.AddProxyDB(ipProxy)
-
 	}
 
-	if config.Data.UseGEODetection {
-		err, ipGeo := ipdb.Create(config.Data.GeoBlockCSV)
-		if err != nil {
-			log.Printf("IPDB read issue: %s \n", err.Error())
-			os.Exit(1)
-		}
+	err = jail.Setup(firewall)
+	if err != nil {
+		log.Printf("Firewall setup issue: %s \n", err.Error())
+		os.Exit(1)
+	}
 
-		//add loaded proxy checker to watcher system
-		watch.AddGeoDB(ipGeo)
+	//load the blocklists in the background
+	blocklist.Setup(conf.Blocklists)
 
+	//add proxy and geo checkers to the watcher system
+	err = watch.LoadDatabases()
+	if err != nil {
+		log.Printf("IPDB read issue: %s \n", err.Error())
+		os.Exit(1)
 	}
 
+	//DNSBL and other reputation lookups
+	watch.SetupReputation()
+
 	//Launch main watcher loop
 	go watch.Run()
 
 	//Launch webserver
-	go web.Webserver()
+	err = web.Webserver()
+	if err != nil {
+		log.Printf("Webserver issue: %s \n", err.Error())
+		os.Exit(1)
+	}
+
+	//Launch control socket for "ipvoid ctl"
+	go func() {
+		err := ctl.Serve(conf.ControlSocket)
+		if err != nil {
+			log.Printf("Control socket issue: %s \n", err.Error())
+		}
+	}()
+
+	//reload config and rules
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	go func() {
+		for range hangup {
+			log.Println("SIGHUP: reloading config")
+			watch.Reload()
+		}
+	}()
 
 	//graceful shutdown
 	terminate := make(chan os.Signal, 1)
@@ -71,8 +120,42 @@ func main() {
 	<-terminate
 	log.Println("Shutting down")
 
+	ctl.Close()
+	web.Shutdown(5 * time.Second)
+	jail.StoreState()
 	jail.ClearJail()
 	watch.StoreState()
 
 	os.Exit(0)
 }
+
+func newFirewall(backend string) (jail.Firewall, error) {
+	switch backend {
+	case "", "iptables", "ipset":
+		//Init IP Tables interface
+		ipt, err := iptables.New()
+		if err != nil {
+			return nil, err
+		}
+
+		//IPv6 is optional, hosts without ip6tables only ban IPv4 addresses
+		ipt6, err := iptables.NewWithProtocol(iptables.ProtocolIPv6)
+		if err != nil {
+			log.Printf("IP6tables init issue, IPv6 addresses won't be blocked: %s \n", err.Error())
+			if backend == "ipset" {
+				return jail.NewIPSetFirewall(ipt, nil), nil
+			}
+			return jail.NewIPTablesFirewall(ipt, nil), nil
+		}
+
+		if backend == "ipset" {
+			return jail.NewIPSetFirewall(ipt, ipt6), nil
+		}
+		return jail.NewIPTablesFirewall(ipt, ipt6), nil
+
+	case "nftables":
+		return jail.NewNFTablesFirewall(), nil
+	}
+
+	return nil, errors.New("unknown firewall backend: " + backend)
+}
@@ -0,0 +1,327 @@
+package jail
+
+import (
+	"errors"
+	"fmt"
+	"ipvoid/metrics"
+	"ipvoid/voidlog"
+	"net"
+	"sort"
+	"sync"
+)
+
+// ListFirewall is implemented by the backends that enforce imported
+// blocklists apart from the jail, in their own chain or sets. Listed
+// entries never expire, they are removed when their lists drop them.
+type ListFirewall interface {
+	//InitLists prepares the empty blocklist chain or sets
+	InitLists() error
+	//ClearLists removes every listed entry from the firewall
+	ClearLists() error
+	//BanListed blocks ip, an address or a CIDR, on behalf of a blocklist
+	BanListed(ip string) error
+	//UnbanListed removes ip, an address or a CIDR, from the blocklist chain or sets
+	UnbanListed(ip string) error
+}
+
+var setLock = sync.Mutex{}                    //serializes the changes of the lists, held during firewall calls
+var listLock = sync.RWMutex{}                 //guards the maps, never held during firewall calls
+var lists = make(map[string]map[string]bool)  //list name: entries, guarded by listLock
+var listed = make(map[string]map[string]bool) //entry: list names, guarded by listLock
+var listNets = make(map[string][]*net.IPNet)  //list name: parsed entries, guarded by listLock
+var listEntries = make(map[string][]string)   //list name: entries as set, whitelisted ones included. Guarded by setLock
+var installed = make(map[string]bool)         //entries in the firewall, the cover of all lists. Guarded by setLock
+
+// iptablesListLimit caps the listed entries of the plain iptables backend:
+// each rule is checked against the whole chain, large lists take hours
+var iptablesListLimit = 5000
+
+func init() {
+	metrics.NewGaugeFunc("ipvoid_blocklist_entries", "Entries of the imported blocklists in the firewall.", func() float64 {
+		listLock.RLock()
+		defer listLock.RUnlock()
+		return float64(len(listed))
+	})
+}
+
+// SetList replaces the entries of the blocklist name. New entries are
+// installed in the firewall and the dropped ones removed, unless another
+// list has them. Entries contained in another entry of any list are only
+// installed once that one is gone. Entries overlapping the whitelist are
+// skipped. An error is returned when entries couldn't be installed, the
+// others are kept.
+func SetList(name string, entries []string) (added int, removed int, err error) {
+	setLock.Lock()
+	defer setLock.Unlock()
+
+	added, removed, err = setList(name, entries)
+	if err == nil || added > 0 || removed > 0 {
+		if len(entries) == 0 {
+			delete(listEntries, name)
+		} else {
+			listEntries[name] = entries
+		}
+	}
+	return added, removed, err
+}
+
+// setList is SetList, called with setLock held
+func setList(name string, entries []string) (added int, removed int, err error) {
+	lf, ok := fw.(ListFirewall)
+	if !ok && len(entries) > 0 {
+		return 0, 0, errors.New("the firewall backend doesn't support blocklists")
+	}
+
+	next := make(map[string]bool, len(entries))
+	for _, e := range entries {
+		e, ipnet, err := parseEntry(e)
+		if err != nil {
+			continue
+		}
+		if whitelistOverlaps(ipnet) {
+			whitelistSkips.Inc()
+			continue
+		}
+		next[e] = true
+	}
+
+	//the changes of the firewall, setLock keeps the maps as they are.
+	//Entries contained in another one aren't installed: nftables interval
+	//sets refuse overlapping elements.
+	listLock.RLock()
+	prev := lists[name]
+	all := make(map[string]bool, len(listed)+len(next))
+	for e, names := range listed {
+		if len(names) > 1 || !names[name] {
+			all[e] = true
+		}
+	}
+	listLock.RUnlock()
+	for e := range next {
+		all[e] = true
+	}
+	wanted := cover(all)
+
+	var install, uninstall []string
+	for e := range wanted {
+		if !installed[e] {
+			install = append(install, e)
+		}
+	}
+	for e := range installed {
+		if !wanted[e] {
+			uninstall = append(uninstall, e)
+		}
+	}
+
+	if _, plain := fw.(*IPTablesFirewall); plain && len(wanted) > iptablesListLimit {
+		return 0, 0, fmt.Errorf("%d blocklist entries are too many for the iptables backend (at most %d), use ipset or nftables",
+			len(wanted), iptablesListLimit)
+	}
+
+	//removed first, an entry can be replaced by one containing it
+	for _, e := range uninstall {
+		err := lf.UnbanListed(e)
+		if err != nil {
+			firewallErrors.Inc()
+			voidlog.Logf("Blocklist %s: removing %s failed: %v \n", name, e, err)
+			continue
+		}
+		delete(installed, e)
+	}
+	failed := 0
+	var firstErr error
+	for _, e := range install {
+		err := lf.BanListed(e)
+		if err != nil {
+			firewallErrors.Inc()
+			failed++
+			if firstErr == nil {
+				firstErr = err
+			}
+			delete(next, e)
+			continue
+		}
+		installed[e] = true
+	}
+
+	var nets []*net.IPNet
+	if len(next) > 0 {
+		nets = make([]*net.IPNet, 0, len(next))
+		for e := range next {
+			_, ipnet, _ := parseEntry(e)
+			nets = append(nets, ipnet)
+		}
+	}
+
+	listLock.Lock()
+	defer listLock.Unlock()
+	for e := range next {
+		if prev[e] {
+			continue
+		}
+		if listed[e] == nil {
+			listed[e] = make(map[string]bool, 1)
+		}
+		listed[e][name] = true
+		added++
+	}
+	for e := range prev {
+		if next[e] {
+			continue
+		}
+		delete(listed[e], name)
+		if len(listed[e]) == 0 {
+			delete(listed, e)
+		}
+		removed++
+	}
+	if len(next) == 0 {
+		delete(lists, name)
+		delete(listNets, name)
+	} else {
+		lists[name] = next
+		listNets[name] = nets
+	}
+
+	if failed > 0 {
+		err = fmt.Errorf("%d entries not installed: %v", failed, firstErr)
+	}
+	return added, removed, err
+}
+
+// cover returns the entries not contained in another one of entries
+func cover(entries map[string]bool) map[string]bool {
+	//the prefix lengths in use, per address length
+	nets := make(map[string]*net.IPNet, len(entries))
+	lengths := make(map[int]map[int]bool, 2)
+	for e := range entries {
+		_, ipnet, err := parseEntry(e)
+		if err != nil {
+			continue
+		}
+		nets[e] = ipnet
+		ones, bits := ipnet.Mask.Size()
+		if lengths[bits] == nil {
+			lengths[bits] = make(map[int]bool)
+		}
+		lengths[bits][ones] = true
+	}
+
+	res := make(map[string]bool, len(nets))
+	for e, ipnet := range nets {
+		ones, bits := ipnet.Mask.Size()
+		contained := false
+		for l := range lengths[bits] {
+			if l >= ones {
+				continue
+			}
+			mask := net.CIDRMask(l, bits)
+			if entries[(&net.IPNet{IP: ipnet.IP.Mask(mask), Mask: mask}).String()] {
+				contained = true
+				break
+			}
+		}
+		if !contained {
+			res[e] = true
+		}
+	}
+	return res
+}
+
+// reapplyLists sets the blocklists again after a change of the whitelist:
+// entries now whitelisted are removed, the ones no longer are installed
+func reapplyLists() {
+	setLock.Lock()
+	defer setLock.Unlock()
+	for name, entries := range listEntries {
+		added, removed, err := setList(name, entries)
+		if err != nil {
+			voidlog.Logf("Blocklist %s: %s \n", name, err.Error())
+		}
+		if added > 0 || removed > 0 {
+			voidlog.Logf("Blocklist %s: whitelist changed, %d entries added, %d removed \n", name, added, removed)
+		}
+	}
+}
+
+// Listed returns the names of the blocklists with an entry containing ip,
+// an address or a CIDR
+func Listed(ip string) []string {
+	res := net.ParseIP(ip)
+	if res == nil {
+		_, ipnet, err := net.ParseCIDR(ip)
+		if err != nil {
+			return nil
+		}
+		res = ipnet.IP
+	}
+
+	listLock.RLock()
+	defer listLock.RUnlock()
+	var names []string
+	for name, nets := range listNets {
+		for _, n := range nets {
+			if n.Contains(res) {
+				names = append(names, name)
+				break
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ListSize returns the number of entries of the blocklist name in the firewall
+func ListSize(name string) int {
+	listLock.RLock()
+	defer listLock.RUnlock()
+	return len(lists[name])
+}
+
+// parseEntry parses a blocklist entry, an address or a CIDR, and returns it
+// normalized. A CIDR of a single address is normalized to the address.
+func parseEntry(e string) (string, *net.IPNet, error) {
+	if ip := net.ParseIP(e); ip != nil {
+		bits := 128
+		if ip.To4() != nil {
+			ip, bits = ip.To4(), 32
+		}
+		return ip.String(), &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+	}
+
+	_, ipnet, err := net.ParseCIDR(e)
+	if err != nil {
+		return "", nil, errors.New("Parameter is not an IP or a CIDR: " + e)
+	}
+	if ones, bits := ipnet.Mask.Size(); ones == bits {
+		return ipnet.IP.String(), ipnet, nil
+	}
+	return ipnet.String(), ipnet, nil
+}
+
+func initLists() error {
+	lf, ok := fw.(ListFirewall)
+	if !ok {
+		return nil
+	}
+
+	setLock.Lock()
+	defer setLock.Unlock()
+	listLock.Lock()
+	lists = make(map[string]map[string]bool)
+	listed = make(map[string]map[string]bool)
+	listNets = make(map[string][]*net.IPNet)
+	listEntries = make(map[string][]string)
+	listLock.Unlock()
+	installed = make(map[string]bool)
+	return lf.InitLists()
+}
+
+func clearLists() error {
+	lf, ok := fw.(ListFirewall)
+	if !ok {
+		return nil
+	}
+	return lf.ClearLists()
+}
@@ -0,0 +1,170 @@
+package jail
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestSetList(t *testing.T) {
+	added, removed, err := SetList("drop", []string{"198.51.100.0/24", "203.0.113.7/32", "127.0.0.0/8", "nonsense"})
+	if err != nil || added != 2 || removed != 0 {
+		t.Fatalf("unexpected first load: %d added, %d removed, %v \n", added, removed, err)
+	}
+	for _, e := range []string{"198.51.100.0/24", "203.0.113.7"} {
+		if !mf4.blocked(e) {
+			t.Fatalf("%s not installed \n", e)
+		}
+	}
+	if mf4.blocked("127.0.0.0/8") {
+		t.Fatalf("entry overlapping the whitelist installed \n")
+	}
+
+	//another list with a common entry
+	SetList("netset", []string{"203.0.113.7", "192.0.2.128/25"})
+	if res := fmt.Sprint(Listed("203.0.113.7")); res != "[drop netset]" {
+		t.Fatalf("unexpected lists of 203.0.113.7: %s \n", res)
+	}
+	if res := fmt.Sprint(Listed("198.51.100.9")); res != "[drop]" {
+		t.Fatalf("unexpected lists of 198.51.100.9: %s \n", res)
+	}
+
+	//the new version drops both entries, 203.0.113.7 is still on netset
+	added, removed, err = SetList("drop", []string{"198.18.0.0/15"})
+	if err != nil || added != 1 || removed != 2 {
+		t.Fatalf("unexpected diff: %d added, %d removed, %v \n", added, removed, err)
+	}
+	if mf4.blocked("198.51.100.0/24") {
+		t.Fatalf("dropped entry still installed \n")
+	}
+	if !mf4.blocked("203.0.113.7") {
+		t.Fatalf("entry of another list removed \n")
+	}
+	if ListSize("drop") != 1 || ListSize("netset") != 2 {
+		t.Fatalf("unexpected list sizes %d %d \n", ListSize("drop"), ListSize("netset"))
+	}
+
+	SetList("drop", nil)
+	SetList("netset", nil)
+	if Listed("203.0.113.7") != nil || ListSize("drop") != 0 {
+		t.Fatalf("lists not released \n")
+	}
+	if mf4.blocked("203.0.113.7") {
+		t.Fatalf("released entry still installed \n")
+	}
+}
+
+// slowLists holds BanListed until release is closed
+type slowLists struct {
+	DryRunFirewall
+	started chan bool
+	release chan bool
+}
+
+func (s *slowLists) BanListed(ip string) error {
+	s.started <- true
+	<-s.release
+	return nil
+}
+
+func TestSetListUnlocked(t *testing.T) {
+	slow := &slowLists{started: make(chan bool, 1), release: make(chan bool)}
+	prev := fw
+	fw = slow
+	defer func() { fw = prev }()
+
+	done := make(chan bool)
+	go func() {
+		SetList("slow", []string{"192.0.2.0/24"})
+		close(done)
+	}()
+	<-slow.started
+
+	//lookups don't wait for the firewall
+	looked := make(chan bool)
+	go func() {
+		Listed("192.0.2.1")
+		close(looked)
+	}()
+	select {
+	case <-looked:
+	case <-time.After(time.Second):
+		t.Fatalf("Listed blocked by the firewall calls \n")
+	}
+
+	close(slow.release)
+	<-done
+	if res := fmt.Sprint(Listed("192.0.2.1")); res != "[slow]" {
+		t.Fatalf("unexpected lists: %s \n", res)
+	}
+	SetList("slow", nil)
+}
+
+func TestSetListLimit(t *testing.T) {
+	iptablesListLimit = 2
+	defer func() { iptablesListLimit = 5000 }()
+
+	_, _, err := SetList("big", []string{"192.0.2.1", "192.0.2.2", "192.0.2.3"})
+	if err == nil || ListSize("big") != 0 {
+		t.Fatalf("list over the iptables limit installed \n")
+	}
+	if mf4.blocked("192.0.2.1") {
+		t.Fatalf("entry of a rejected list installed \n")
+	}
+}
+
+func TestSetListWhitelist(t *testing.T) {
+	SetList("drop", []string{"198.51.100.0/24", "203.0.113.7"})
+	defer SetList("drop", nil)
+
+	AppendWhitelist("203.0.113.0/24")
+	if mf4.blocked("203.0.113.7") || Listed("203.0.113.7") != nil {
+		t.Fatalf("newly whitelisted entry still installed \n")
+	}
+
+	RemoveWhitelist("203.0.113.0/24")
+	if !mf4.blocked("203.0.113.7") || ListSize("drop") != 2 {
+		t.Fatalf("entry not installed again once out of the whitelist \n")
+	}
+}
+
+func TestSetListOverlap(t *testing.T) {
+	mr := newMockRunner()
+	prev := fw
+	fw = &NFTablesFirewall{run: mr}
+	defer func() { fw = prev }()
+
+	//an address and a subnet of a network of another list
+	_, _, err := SetList("drop", []string{"198.51.100.0/24"})
+	if err == nil {
+		_, _, err = SetList("feed", []string{"198.51.100.7", "198.51.100.128/25", "203.0.113.1"})
+	}
+	if err != nil {
+		t.Fatalf("overlapping lists not installed: %v \n", err)
+	}
+	if fmt.Sprint(Listed("198.51.100.7")) != "[drop feed]" || ListSize("feed") != 3 {
+		t.Fatalf("unexpected lists: %v %d \n", Listed("198.51.100.7"), ListSize("feed"))
+	}
+	if len(mr.elements) != 2 {
+		t.Fatalf("contained entries installed: %v \n", mr.elements)
+	}
+
+	//the contained entries take over once the network is gone
+	_, _, err = SetList("drop", nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v \n", err)
+	}
+	for _, e := range []string{"198.51.100.7", "198.51.100.128/25", "203.0.113.1"} {
+		if _, ok := mr.elements["lists4 "+e]; !ok {
+			t.Fatalf("%s not installed, got %v \n", e, mr.elements)
+		}
+	}
+	if len(mr.elements) != 3 {
+		t.Fatalf("unexpected elements: %v \n", mr.elements)
+	}
+
+	SetList("feed", nil)
+	if len(mr.elements) != 0 {
+		t.Fatalf("entries left: %v \n", mr.elements)
+	}
+}
@@ -0,0 +1,115 @@
+package jail
+
+import (
+	"container/ring"
+	"ipvoid/config"
+	"ipvoid/notify"
+	"ipvoid/voidlog"
+	"time"
+)
+
+// DryRunFirewall bans nothing. The jail runs as usual, but only records
+// the bans it would have made.
+type DryRunFirewall struct{}
+
+func (DryRunFirewall) Init() error                                { return nil }
+func (DryRunFirewall) Clear() error                               { return nil }
+func (DryRunFirewall) Ban(ip string, timeout time.Duration) error { return nil }
+func (DryRunFirewall) Unban(ip string) error                      { return nil }
+func (DryRunFirewall) InitLists() error                           { return nil }
+func (DryRunFirewall) ClearLists() error                          { return nil }
+func (DryRunFirewall) BanListed(ip string) error                  { return nil }
+func (DryRunFirewall) UnbanListed(ip string) error                { return nil }
+
+// WouldBan is a ban that wasn't enforced because of the dry run
+type WouldBan struct {
+	Time   time.Time
+	IP     string
+	Points float32
+	Repeat int
+	Reason string
+}
+
+var dryRun bool
+var wouldBans = ring.New(1024) //guarded by lock
+var onWouldBan func(WouldBan)
+
+// Simulate runs an empty jail in dry run mode on clock, without the
+// scheduler. Replays advance it with Tick and get every would-be ban in
+// record. The returned function puts back the jail as it was.
+func Simulate(clock func() time.Time, record func(WouldBan)) (restore func()) {
+	lock.Lock()
+	prevFw, prevDryRun, prevNow, prevRecord := fw, dryRun, now, onWouldBan
+	prevList, prevRepeat, prevTimes := Ip_list, RepeatViolations, jailTimes
+	prevPermanent, prevHistory, prevWouldBans := permanentBans, JailHistory, wouldBans
+	fw, dryRun = DryRunFirewall{}, true
+	now, onWouldBan = clock, record
+	Ip_list = make(map[string]float32, 1024)
+	RepeatViolations = make(map[string]int, 1024)
+	jailTimes = make(map[string]time.Time, 1024)
+	permanentBans = make(map[string]bool)
+	JailHistory, wouldBans = ring.New(1024), ring.New(1024)
+	lock.Unlock()
+
+	whitelistLock.RLock()
+	prevWhitelist := whitelist
+	whitelistLock.RUnlock()
+	setWhitelist(config.Get().CIDRWhitelist)
+
+	return func() {
+		lock.Lock()
+		fw, dryRun, now, onWouldBan = prevFw, prevDryRun, prevNow, prevRecord
+		Ip_list, RepeatViolations, jailTimes = prevList, prevRepeat, prevTimes
+		permanentBans, JailHistory, wouldBans = prevPermanent, prevHistory, prevWouldBans
+		lock.Unlock()
+
+		whitelistLock.Lock()
+		whitelist = prevWhitelist
+		whitelistLock.Unlock()
+	}
+}
+
+// DryRun reports if the jail runs without a firewall
+func DryRun() bool {
+	return dryRun
+}
+
+// WouldBans returns the bans recorded in dry run mode, newest first
+func WouldBans() []WouldBan {
+	lock.RLock()
+	defer lock.RUnlock()
+	var res []WouldBan
+	wouldBans.Do(func(p interface{}) {
+		if p != nil {
+			res = append(res, p.(WouldBan))
+		}
+	})
+	for i, j := 0, len(res)-1; i < j; i, j = i+1, j-1 {
+		res[i], res[j] = res[j], res[i]
+	}
+	return res
+}
+
+// recordBan keeps a ban for the dry run report. Called with lock held.
+func recordBan(ip string, points float32, repeat int, reason string) {
+	if !dryRun {
+		return
+	}
+	if reason == "" {
+		reason = "score"
+	}
+	wouldBans.Value = WouldBan{now(), ip, points, repeat, reason}
+	wouldBans = wouldBans.Next()
+	if onWouldBan != nil {
+		onWouldBan(wouldBans.Prev().Value.(WouldBan))
+	}
+	voidlog.Logf("DRY-RUN: %s would be jailed with %.2f points (%s) \n", ip, points, reason)
+}
+
+// publish sends jail events, unless they didn't really happen
+func publish(e notify.Event) {
+	if dryRun {
+		return
+	}
+	notify.Publish(e)
+}
@@ -0,0 +1,40 @@
+package jail
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestDryRun(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jail")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	statedir = dir
+	defer func() { statedir = "state" }()
+
+	fw, dryRun = DryRunFirewall{}, true
+	defer func() { fw, dryRun = NewIPTablesFirewall(mf4, mf6), false }()
+
+	BlockIPReason("10.4.4.4", 10, "rule php-probe")
+	if !Jailed("10.4.4.4") {
+		t.Fatalf("dry run doesn't keep the jail \n")
+	}
+	if mf4.blocked("10.4.4.4") {
+		t.Fatalf("dry run touched the firewall \n")
+	}
+
+	bans := WouldBans()
+	if len(bans) == 0 || bans[0].IP != "10.4.4.4" || bans[0].Points != 10 || bans[0].Reason != "rule php-probe" {
+		t.Fatalf("would-be ban not recorded: %+v \n", bans)
+	}
+
+	StoreState()
+	if _, err := os.Stat(dir + "/jail"); !os.IsNotExist(err) {
+		t.Fatalf("dry run saved the jail state \n")
+	}
+
+	UnblockIP("10.4.4.4")
+}
@@ -0,0 +1,120 @@
+package jail
+
+import (
+	"bufio"
+	"io/ioutil"
+	"ipvoid/config"
+	"log"
+	"net"
+	"os"
+	"sort"
+	"strings"
+)
+
+// permanentBans never leave the jail. Guarded by lock.
+var permanentBans = make(map[string]bool)
+
+// escalate returns the jail points of the repeat-th violation, or permanent.
+// Without an escalation policy the points are multiplied by the repeat count.
+func escalate(points float32, repeat int) (float32, bool) {
+	steps := config.Get().Escalation
+	if len(steps) == 0 {
+		return points * float32(repeat), false
+	}
+
+	step := steps[len(steps)-1]
+	if repeat <= len(steps) {
+		step = steps[repeat-1]
+	}
+	if step == config.PermanentBan {
+		return points, true
+	}
+	return float32(float64(step)/float64(schedulerSleep)) * decJailedPerCycle, false
+}
+
+// decayed reports if the repeat violations of ip are older than the decay window
+func decayed(ip string) bool {
+	t, ok := jailTimes[ip]
+	window := config.Get().RepeatDecayTime
+	return ok && window > 0 && now().Sub(t) > window
+}
+
+// forgetDecayed drops the repeat violations of released IPs past the decay window
+func forgetDecayed() {
+	for ip := range jailTimes {
+		if _, jailed := Ip_list[ip]; !jailed && decayed(ip) {
+			delete(jailTimes, ip)
+			delete(RepeatViolations, ip)
+		}
+	}
+}
+
+// Permanent reports if ip is banned for good
+func Permanent(ip string) bool {
+	lock.RLock()
+	defer lock.RUnlock()
+	return permanentBans[ip]
+}
+
+// loadPermanent bans the IPs of the permanent ban list and the saved state
+func loadPermanent() {
+	lock.Lock()
+	defer lock.Unlock()
+
+	if path := config.Get().PermanentBanFile; path != "" {
+		file, err := os.Open(path)
+		if err == nil {
+			scanner := bufio.NewScanner(file)
+			for scanner.Scan() {
+				line := strings.TrimSpace(scanner.Text())
+				if line == "" || strings.HasPrefix(line, "#") {
+					continue
+				}
+				if res := net.ParseIP(line); res != nil {
+					permanentBans[res.String()] = true
+				}
+			}
+			file.Close()
+		} else if !os.IsNotExist(err) {
+			log.Printf("Couldn't read the permanent ban list: %s \n", err.Error())
+		}
+	}
+
+	for ip := range permanentBans {
+		if whitelisted(net.ParseIP(ip)) {
+			continue
+		}
+		err := fw.Ban(ip, 0)
+		if err != nil {
+			log.Printf("Couldn't restore permanent ban of %s: %v \n", ip, err)
+			continue
+		}
+		if _, ok := Ip_list[ip]; !ok {
+			Ip_list[ip] = 0
+		}
+	}
+}
+
+// savePermanent writes the permanent ban list, one IP per line. Called with
+// lock held.
+func savePermanent() {
+	path := config.Get().PermanentBanFile
+	if path == "" || dryRun {
+		return
+	}
+
+	ips := make([]string, 0, len(permanentBans))
+	for ip := range permanentBans {
+		ips = append(ips, ip)
+	}
+	sort.Strings(ips)
+
+	tmp := path + ".tmp"
+	err := ioutil.WriteFile(tmp, []byte(strings.Join(ips, "\n")+"\n"), 0644)
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		log.Printf("Couldn't save the permanent ban list: %s \n", err.Error())
+	}
+}
@@ -0,0 +1,149 @@
+package jail
+
+import (
+	"io/ioutil"
+	"ipvoid/config"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// violate jails ip as a new violation, not as a score increase of the last one
+func violate(ip string) {
+	lock.Lock()
+	if t, ok := jailTimes[ip]; ok {
+		jailTimes[ip] = t.Add(-time.Minute)
+	}
+	delete(Ip_list, ip)
+	lock.Unlock()
+	BlockIP(ip, 1000)
+}
+
+func TestEscalation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jail")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	defer config.Set(config.Get())
+	conf := *config.Get()
+	conf.Escalation = []time.Duration{time.Second, 2 * time.Second, config.PermanentBan}
+	conf.PermanentBanFile = dir + "/permanent"
+	config.Set(&conf)
+
+	ip := "10.7.7.7"
+	expected := []float32{10, 20}
+	for i, points := range expected {
+		violate(ip)
+		p, jailed, repeat := IPStatus(ip)
+		if !jailed || p != points || repeat != i+1 {
+			t.Fatalf("violation %d: expected %.0f points, got %.2f (jailed %v, repeat %d) \n", i+1, points, p, jailed, repeat)
+		}
+	}
+
+	violate(ip)
+	if !Permanent(ip) {
+		t.Fatalf("third violation is not permanent \n")
+	}
+	content, _ := ioutil.ReadFile(conf.PermanentBanFile)
+	if strings.TrimSpace(string(content)) != ip {
+		t.Fatalf("unexpected permanent ban list: %q \n", content)
+	}
+
+	//permanent bans are not released by the scheduler
+	time.Sleep(3 * schedulerSleep)
+	if !Jailed(ip) {
+		t.Fatalf("permanent ban released \n")
+	}
+
+	UnblockIP(ip)
+	if Permanent(ip) || Jailed(ip) {
+		t.Fatalf("permanent ban not removed \n")
+	}
+	content, _ = ioutil.ReadFile(conf.PermanentBanFile)
+	if strings.TrimSpace(string(content)) != "" {
+		t.Fatalf("permanent ban list not updated: %q \n", content)
+	}
+}
+
+func TestRepeatDecay(t *testing.T) {
+	defer config.Set(config.Get())
+	conf := *config.Get()
+	conf.RepeatDecayTime = time.Hour
+	config.Set(&conf)
+
+	ip := "10.7.7.8"
+	BlockIP(ip, 1)
+	lock.Lock()
+	RepeatViolations[ip] = 5
+	jailTimes[ip] = time.Now().Add(-2 * time.Hour)
+	delete(Ip_list, ip)
+	lock.Unlock()
+
+	BlockIP(ip, 1)
+	if _, _, repeat := IPStatus(ip); repeat != 1 {
+		t.Fatalf("repeat violations not reset after the decay window: %d \n", repeat)
+	}
+
+	//released IPs past the decay window are forgotten
+	lock.Lock()
+	delete(Ip_list, ip)
+	jailTimes[ip] = time.Now().Add(-2 * time.Hour)
+	lock.Unlock()
+	decreaseJailTime()
+	if _, _, repeat := IPStatus(ip); repeat != 0 {
+		t.Fatalf("decayed repeat violations not forgotten: %d \n", repeat)
+	}
+}
+
+func TestEscalationReblock(t *testing.T) {
+	defer config.Set(config.Get())
+	conf := *config.Get()
+	conf.Escalation = []time.Duration{time.Second, time.Hour}
+	config.Set(&conf)
+
+	ip := "10.7.7.9"
+	violate(ip)
+	violate(ip)
+	escalated, _, _ := IPStatus(ip)
+
+	//a match within 10 seconds must not lower the escalated jail time
+	BlockIP(ip, 5)
+	if p, jailed, repeat := IPStatus(ip); !jailed || p != escalated || repeat != 2 {
+		t.Fatalf("expected %.0f points after the re-block, got %.2f (jailed %v, repeat %d) \n", escalated, p, jailed, repeat)
+	}
+	UnblockIP(ip)
+}
+
+func TestManualBanRepeat(t *testing.T) {
+	ip := "10.7.7.10"
+	BlockIPFor(ip, 10)
+	if _, jailed, repeat := IPStatus(ip); !jailed || repeat != 0 {
+		t.Fatalf("manual ban counted as a repeat violation: %d \n", repeat)
+	}
+	UnblockIP(ip)
+}
+
+func TestManualBanPermanent(t *testing.T) {
+	ip := "10.7.7.11"
+	BlockIP(ip, 10)
+	lock.Lock()
+	permanentBans[ip] = true
+	lock.Unlock()
+	defer func() {
+		lock.Lock()
+		delete(permanentBans, ip)
+		delete(Ip_list, ip)
+		lock.Unlock()
+	}()
+
+	//a timed ban would replace the permanent firewall entry
+	if err := BlockIPFor(ip, 10); err == nil {
+		t.Fatalf("manual ban of a permanently banned IP \n")
+	}
+	if points, _, _ := IPStatus(ip); points != 10 {
+		t.Fatalf("permanent ban changed to %.0f minutes \n", points)
+	}
+}
@@ -0,0 +1,53 @@
+package jail
+
+import (
+	"errors"
+	"net"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// Firewall is the backend that enforces the bans of the jail.
+type Firewall interface {
+	//Init prepares an empty jail in the firewall
+	Init() error
+	//Clear removes every banned IP from the firewall
+	Clear() error
+	//Ban blocks ip, an address or a CIDR. Backends supporting it expire the
+	//ban after timeout, a zero timeout never expires
+	Ban(ip string, timeout time.Duration) error
+	//Unban removes ip, an address or a CIDR, from the firewall
+	Unban(ip string) error
+}
+
+// commandRunner executes firewall tools (nft, ipset) so they can be mocked in tests
+type commandRunner interface {
+	Run(name string, args ...string) error
+}
+
+type execRunner struct{}
+
+func (execRunner) Run(name string, args ...string) error {
+	out, err := exec.Command(name, args...).CombinedOutput()
+	if err != nil {
+		return errors.New(name + " " + strings.Join(args, " ") + ": " + strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// isIPv4 reports if ip, an address or a CIDR, is IPv4
+func isIPv4(ip string) (bool, error) {
+	if _, ipnet, err := net.ParseCIDR(ip); err == nil {
+		return ipnet.IP.To4() != nil, nil
+	}
+	res := net.ParseIP(ip)
+	if res == nil {
+		return false, errors.New("Parameter is not an IP")
+	}
+	return res.To4() != nil, nil
+}
+
+func isCIDR(ip string) bool {
+	return strings.Contains(ip, "/")
+}
@@ -0,0 +1,242 @@
+package jail
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+const (
+	ipsetName4    = chain
+	ipsetName6    = chain + "6"
+	ipsetNetName4 = chain + "-net"
+	ipsetNetName6 = chain + "-net6"
+	ipsetList4    = listChain
+	ipsetList6    = listChain + "6"
+)
+
+// IPSetFirewall keeps banned IPs in hash:ip sets, and banned subnets in
+// hash:net sets, matched by a single iptables rule per set instead of one
+// rule per banned IP.
+type IPSetFirewall struct {
+	ipt  iptablesImp
+	ipt6 iptablesImp
+	run  commandRunner
+}
+
+// NewIPSetFirewall creates an ipset backend.
+// ipt6 can be nil, in which case IPv6 addresses are not blocked.
+func NewIPSetFirewall(ipt iptablesImp, ipt6 iptablesImp) *IPSetFirewall {
+	return &IPSetFirewall{ipt: ipt, ipt6: ipt6, run: execRunner{}}
+}
+
+func (f *IPSetFirewall) ipset(args ...string) error {
+	return f.run.Run("ipset", args...)
+}
+
+func (f *IPSetFirewall) Init() error {
+	families := []struct {
+		t      iptablesImp
+		set    string
+		netSet string
+		family string
+	}{
+		{f.ipt, ipsetName4, ipsetNetName4, "inet"},
+		{f.ipt6, ipsetName6, ipsetNetName6, "inet6"},
+	}
+
+	for _, fam := range families {
+		if fam.t == nil {
+			continue
+		}
+
+		//"timeout 0" enables per member timeouts without a default one
+		err := f.ipset("create", fam.set, "hash:ip", "family", fam.family, "timeout", "0", "-exist")
+		if err == nil {
+			err = f.ipset("flush", fam.set)
+		}
+		if err == nil {
+			err = f.ipset("create", fam.netSet, "hash:net", "family", fam.family, "timeout", "0", "-exist")
+		}
+		if err == nil {
+			err = f.ipset("flush", fam.netSet)
+		}
+		if err != nil {
+			fmt.Printf("IPset setup issue: %v \n", err)
+			return err
+		}
+
+		err = fam.t.ClearChain("filter", chain)
+		if err != nil {
+			fmt.Printf("IPtables clear chain issue: %v \n", err)
+			return err
+		}
+
+		err = fam.t.AppendUnique("filter", "INPUT", "-j", chain)
+		if err != nil {
+			fmt.Printf("IPtables attach chain issue: %v \n", err)
+			return err
+		}
+
+		for _, set := range []string{fam.set, fam.netSet} {
+			err = fam.t.AppendUnique("filter", chain, "-m", "set", "--match-set", set, "src", "-j", "DROP")
+			if err != nil {
+				fmt.Printf("IPtables set rule issue: %v \n", err)
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (f *IPSetFirewall) Clear() error {
+	var sets []string
+	if f.ipt != nil {
+		sets = append(sets, ipsetName4, ipsetNetName4)
+	}
+	if f.ipt6 != nil {
+		sets = append(sets, ipsetName6, ipsetNetName6)
+	}
+	for _, set := range sets {
+		err := f.ipset("flush", set)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *IPSetFirewall) Ban(ip string, timeout time.Duration) error {
+	set, err := f.set(ip)
+	if err != nil {
+		return err
+	}
+	//-exist replaces the timeout of a member that is already in the set
+	return f.ipset("add", set, ip, "timeout", strconv.FormatInt(int64(timeout.Seconds()), 10), "-exist")
+}
+
+func (f *IPSetFirewall) Unban(ip string) error {
+	set, err := f.set(ip)
+	if err != nil {
+		return err
+	}
+	return f.ipset("del", set, ip, "-exist")
+}
+
+// set returns the ipset responsible for the IP family of ip, and for
+// subnets
+func (f *IPSetFirewall) set(ip string) (string, error) {
+	v4, err := isIPv4(ip)
+	if err != nil {
+		return "", err
+	}
+	if !v4 && f.ipt6 == nil {
+		return "", errors.New("IPv6 firewall is not available")
+	}
+
+	switch {
+	case v4 && isCIDR(ip):
+		return ipsetNetName4, nil
+	case v4:
+		return ipsetName4, nil
+	case isCIDR(ip):
+		return ipsetNetName6, nil
+	}
+	return ipsetName6, nil
+}
+
+// InitLists creates a hash:net set per IP family for the imported
+// blocklists, matched from their own chain
+func (f *IPSetFirewall) InitLists() error {
+	families := []struct {
+		t      iptablesImp
+		set    string
+		family string
+	}{
+		{f.ipt, ipsetList4, "inet"},
+		{f.ipt6, ipsetList6, "inet6"},
+	}
+
+	for _, fam := range families {
+		if fam.t == nil {
+			continue
+		}
+
+		err := f.ipset("create", fam.set, "hash:net", "family", fam.family, "maxelem", "1048576", "-exist")
+		if err == nil {
+			err = f.ipset("flush", fam.set)
+		}
+		if err != nil {
+			fmt.Printf("IPset setup issue: %v \n", err)
+			return err
+		}
+
+		err = fam.t.ClearChain("filter", listChain)
+		if err != nil {
+			fmt.Printf("IPtables clear chain issue: %v \n", err)
+			return err
+		}
+
+		err = fam.t.AppendUnique("filter", "INPUT", "-j", listChain)
+		if err != nil {
+			fmt.Printf("IPtables attach chain issue: %v \n", err)
+			return err
+		}
+
+		err = fam.t.AppendUnique("filter", listChain, "-m", "set", "--match-set", fam.set, "src", "-j", "DROP")
+		if err != nil {
+			fmt.Printf("IPtables set rule issue: %v \n", err)
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *IPSetFirewall) ClearLists() error {
+	var sets []string
+	if f.ipt != nil {
+		sets = append(sets, ipsetList4)
+	}
+	if f.ipt6 != nil {
+		sets = append(sets, ipsetList6)
+	}
+	for _, set := range sets {
+		err := f.ipset("flush", set)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *IPSetFirewall) BanListed(ip string) error {
+	set, err := f.listSet(ip)
+	if err != nil {
+		return err
+	}
+	return f.ipset("add", set, ip, "-exist")
+}
+
+func (f *IPSetFirewall) UnbanListed(ip string) error {
+	set, err := f.listSet(ip)
+	if err != nil {
+		return err
+	}
+	return f.ipset("del", set, ip, "-exist")
+}
+
+// listSet returns the blocklist set of the IP family of ip
+func (f *IPSetFirewall) listSet(ip string) (string, error) {
+	v4, err := isIPv4(ip)
+	if err != nil {
+		return "", err
+	}
+	if v4 {
+		return ipsetList4, nil
+	}
+	if f.ipt6 == nil {
+		return "", errors.New("IPv6 firewall is not available")
+	}
+	return ipsetList6, nil
+}
@@ -0,0 +1,69 @@
+package jail
+
+import (
+	"testing"
+	"time"
+)
+
+func TestIPSet(t *testing.T) {
+	mr := newMockRunner()
+	ipt4 := newMockFireWall()
+	f := &IPSetFirewall{ipt: ipt4, run: mr}
+
+	err := f.Init()
+	if err != nil {
+		t.Fatalf("Init failed: %s \n", err.Error())
+	}
+	if len(mr.cmds) != 4 || mr.cmds[0] != "ipset create ipvoid hash:ip family inet timeout 0 -exist" {
+		t.Fatalf("unexpected setup commands: %v \n", mr.cmds)
+	}
+	if !ipt4.blocked("set") {
+		t.Fatalf("expected set match rule \n")
+	}
+
+	f.Ban("10.0.0.1", 90*time.Second)
+	last := mr.cmds[len(mr.cmds)-1]
+	if last != "ipset add ipvoid 10.0.0.1 timeout 90 -exist" {
+		t.Fatalf("unexpected command: %s \n", last)
+	}
+
+	f.Unban("10.0.0.1")
+	last = mr.cmds[len(mr.cmds)-1]
+	if last != "ipset del ipvoid 10.0.0.1 -exist" {
+		t.Fatalf("unexpected command: %s \n", last)
+	}
+
+	f.Ban("10.0.1.0/24", time.Minute)
+	last = mr.cmds[len(mr.cmds)-1]
+	if last != "ipset add ipvoid-net 10.0.1.0/24 timeout 60 -exist" {
+		t.Fatalf("unexpected command: %s \n", last)
+	}
+
+	if f.Ban("2001:db8::1", time.Minute) == nil {
+		t.Fatalf("expected error for IPv6 without ip6tables \n")
+	}
+}
+
+func TestIPSetLists(t *testing.T) {
+	mr := newMockRunner()
+	ipt4 := newMockFireWall()
+	f := &IPSetFirewall{ipt: ipt4, run: mr}
+
+	err := f.InitLists()
+	if err != nil {
+		t.Fatalf("InitLists failed: %s \n", err.Error())
+	}
+	if mr.cmds[0] != "ipset create ipvoid-lists hash:net family inet maxelem 1048576 -exist" {
+		t.Fatalf("unexpected setup commands: %v \n", mr.cmds)
+	}
+
+	f.BanListed("198.51.100.7")
+	last := mr.cmds[len(mr.cmds)-1]
+	if last != "ipset add ipvoid-lists 198.51.100.7 -exist" {
+		t.Fatalf("unexpected command: %s \n", last)
+	}
+
+	if f.BanListed("2001:db8::/32") == nil {
+		t.Fatalf("expected error for IPv6 without ip6tables \n")
+	}
+}
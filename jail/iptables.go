@@ -0,0 +1,143 @@
+package jail
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+type iptablesImp interface {
+	AppendUnique(table, chain string, rulespec ...string) error
+	Delete(table, chain string, rulespec ...string) error
+	ClearChain(table, chain string) error
+}
+
+// IPTablesFirewall bans every IP with its own DROP rule in the ipvoid chain.
+type IPTablesFirewall struct {
+	ipt  iptablesImp
+	ipt6 iptablesImp
+}
+
+// NewIPTablesFirewall creates an iptables backend.
+// ipt6 can be nil, in which case IPv6 addresses are not blocked.
+func NewIPTablesFirewall(ipt iptablesImp, ipt6 iptablesImp) *IPTablesFirewall {
+	return &IPTablesFirewall{ipt: ipt, ipt6: ipt6}
+}
+
+func (f *IPTablesFirewall) Init() error {
+	for _, t := range []iptablesImp{f.ipt, f.ipt6} {
+		if t == nil {
+			continue
+		}
+
+		err := t.ClearChain("filter", chain)
+		if err != nil {
+			fmt.Printf("IPtables clear chain issue: %v \n", err)
+			return err
+		}
+
+		err = t.AppendUnique("filter", "INPUT", "-j", chain)
+		if err != nil {
+			fmt.Printf("IPtables attach chain issue: %v \n", err)
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *IPTablesFirewall) Clear() error {
+	for _, t := range []iptablesImp{f.ipt, f.ipt6} {
+		if t == nil {
+			continue
+		}
+		err := t.ClearChain("filter", chain)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *IPTablesFirewall) Ban(ip string, timeout time.Duration) error {
+	t, err := f.tables(ip)
+	if err != nil {
+		return err
+	}
+	return t.AppendUnique("filter", chain, "-s", ip, "-j", "DROP")
+}
+
+func (f *IPTablesFirewall) Unban(ip string) error {
+	t, err := f.tables(ip)
+	if err != nil {
+		return err
+	}
+	return t.Delete("filter", chain, "-s", ip, "-j", "DROP")
+}
+
+// tables returns the iptables responsible for the IP family of ip
+func (f *IPTablesFirewall) tables(ip string) (iptablesImp, error) {
+	v4, err := isIPv4(ip)
+	if err != nil {
+		return nil, err
+	}
+	if v4 {
+		return f.ipt, nil
+	}
+	if f.ipt6 == nil {
+		return nil, errors.New("IPv6 firewall is not available")
+	}
+	return f.ipt6, nil
+}
+
+// listChain holds the DROP rules of the imported blocklists
+const listChain = chain + "-lists"
+
+func (f *IPTablesFirewall) InitLists() error {
+	for _, t := range []iptablesImp{f.ipt, f.ipt6} {
+		if t == nil {
+			continue
+		}
+
+		err := t.ClearChain("filter", listChain)
+		if err != nil {
+			fmt.Printf("IPtables clear chain issue: %v \n", err)
+			return err
+		}
+
+		err = t.AppendUnique("filter", "INPUT", "-j", listChain)
+		if err != nil {
+			fmt.Printf("IPtables attach chain issue: %v \n", err)
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *IPTablesFirewall) ClearLists() error {
+	for _, t := range []iptablesImp{f.ipt, f.ipt6} {
+		if t == nil {
+			continue
+		}
+		err := t.ClearChain("filter", listChain)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *IPTablesFirewall) BanListed(ip string) error {
+	t, err := f.tables(ip)
+	if err != nil {
+		return err
+	}
+	return t.AppendUnique("filter", listChain, "-s", ip, "-j", "DROP")
+}
+
+func (f *IPTablesFirewall) UnbanListed(ip string) error {
+	t, err := f.tables(ip)
+	if err != nil {
+		return err
+	}
+	return t.Delete("filter", listChain, "-s", ip, "-j", "DROP")
+}
@@ -5,69 +5,84 @@ import (
 	"errors"
 	"fmt"
 	"ipvoid/config"
+	"ipvoid/metrics"
+	"ipvoid/notify"
 	"ipvoid/voidlog"
 	"net"
 	"sync"
 	"time"
 )
 
-type iptablesImp interface {
-	AppendUnique(table, chain string, rulespec ...string) error
-	Delete(table, chain string, rulespec ...string) error
-	ClearChain(table, chain string) error
-}
-
 const chain string = "ipvoid"
 
-var ipt iptablesImp
+var fw Firewall
 
 var Ip_list map[string]float32
 var RepeatViolations map[string]int
 var JailHistory *ring.Ring
 var whitelist []*net.IPNet
+var runtimeWhitelist map[string]bool //networks added with AppendWhitelist, kept on reload
 var jailTimes map[string]time.Time
 
 var lock = sync.RWMutex{}
+var whitelistLock = sync.RWMutex{}
 var schedulerSleep = time.Minute
+var now = time.Now //the jail clock, simulated in replays
 var decJailedPerCycle float32 = 1
 
+var (
+	bansTotal      = metrics.NewCounter("ipvoid_bans_total", "IPs put in the jail.")
+	unbansTotal    = metrics.NewCounter("ipvoid_unbans_total", "IPs released from the jail.")
+	whitelistSkips = metrics.NewCounter("ipvoid_whitelist_skips_total", "Bans skipped because the IP is whitelisted.")
+	firewallErrors = metrics.NewCounter("ipvoid_firewall_errors_total", "Failed firewall operations.")
+)
+
 func init() {
 	Ip_list = make(map[string]float32, 1024)
 	RepeatViolations = make(map[string]int, 1024)
 	JailHistory = ring.New(1024)
 	whitelist = make([]*net.IPNet, 0, 100)
+	runtimeWhitelist = make(map[string]bool)
 	jailTimes = make(map[string]time.Time, 1024)
+
+	metrics.NewGaugeFunc("ipvoid_jail_size", "IPs currently jailed.", func() float64 {
+		lock.RLock()
+		defer lock.RUnlock()
+		return float64(len(Ip_list))
+	})
 }
 
-func Setup(iptimp iptablesImp) error {
-	ipt = iptimp
+// Setup prepares the firewall backend for the jail.
+func Setup(firewall Firewall) error {
+	fw = firewall
+	_, dryRun = firewall.(DryRunFirewall)
 
-	err := ipt.ClearChain("filter", chain)
+	err := fw.Init()
 	if err != nil {
-		fmt.Printf("IPtables clear chain issue: %v \n", err)
 		return err
 	}
-
-	err = ipt.AppendUnique("filter", "INPUT", "-j", chain)
+	err = initLists()
 	if err != nil {
-		fmt.Printf("IPtables attach chain issue: %v \n", err)
 		return err
 	}
 
-	for _, cidr := range config.Data.CIDRWhitelist {
-		AppendWhitelist(cidr)
-	}
-	AppendWhitelist("127.0.0.1/32")
+	SetWhitelist(config.Get().CIDRWhitelist)
+	loadState()
+	loadPermanent()
 
 	go scheduledRemoval()
 	return nil
 }
 
 func ClearJail() {
-	fmt.Printf("IPtables chain cleared \n")
-	err := ipt.ClearChain("filter", chain)
+	fmt.Printf("Firewall jail cleared \n")
+	err := fw.Clear()
 	if err != nil {
-		fmt.Printf("IPtables clear chain issue: %v \n", err)
+		fmt.Printf("Firewall clear issue: %v \n", err)
+	}
+	err = clearLists()
+	if err != nil {
+		fmt.Printf("Firewall blocklists clear issue: %v \n", err)
 	}
 }
 
@@ -78,73 +93,341 @@ func AppendWhitelist(cidr string) {
 		return
 	}
 
+	whitelistLock.Lock()
 	whitelist = append(whitelist, ipnet)
+	runtimeWhitelist[ipnet.String()] = true
+	whitelistLock.Unlock()
 	voidlog.Logf("IP net added to whitelist: %s \n", cidr)
+	reapplyLists()
+
+}
+
+// RemoveWhitelist removes a CIDR added to the whitelist
+func RemoveWhitelist(cidr string) error {
+	_, ipnet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return errors.New("parameter is not in a CIDR notation")
+	}
+
+	whitelistLock.Lock()
+	delete(runtimeWhitelist, ipnet.String())
+	for i, n := range whitelist {
+		if n.String() == ipnet.String() {
+			//copied, a replay may hold the previous slice
+			whitelist = append(whitelist[:i:i], whitelist[i+1:]...)
+			whitelistLock.Unlock()
+			voidlog.Logf("IP net removed from whitelist: %s \n", cidr)
+			reapplyLists()
+			return nil
+		}
+	}
+	whitelistLock.Unlock()
+	return errors.New("CIDR is not in the whitelist")
+}
+
+// Whitelist returns the whitelisted networks
+func Whitelist() []string {
+	whitelistLock.RLock()
+	defer whitelistLock.RUnlock()
+	res := make([]string, 0, len(whitelist))
+	for _, n := range whitelist {
+		res = append(res, n.String())
+	}
+	return res
+}
+
+// SetWhitelist replaces the configured whitelist with cidrs and the loopback
+// addresses. Networks added at runtime with AppendWhitelist are kept until
+// they are removed with RemoveWhitelist. Jailed IPs stay jailed, blocklist
+// entries follow the new whitelist.
+func SetWhitelist(cidrs []string) {
+	setWhitelist(cidrs)
+	reapplyLists()
+}
+
+func setWhitelist(cidrs []string) {
+	next := make([]*net.IPNet, 0, len(cidrs)+2)
+	seen := make(map[string]bool, len(cidrs)+2)
+	for _, cidr := range append([]string{"127.0.0.1/32", "::1/128"}, cidrs...) {
+		_, ipnet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			fmt.Printf("Whitelist: parameter is not in a CIDR notation: %s \n", cidr)
+			continue
+		}
+		if !seen[ipnet.String()] {
+			seen[ipnet.String()] = true
+			next = append(next, ipnet)
+			voidlog.Logf("IP net added to whitelist: %s \n", cidr)
+		}
+	}
 
+	//swapped at once, so whitelisted IPs can't be jailed in between
+	whitelistLock.Lock()
+	defer whitelistLock.Unlock()
+	for _, n := range whitelist {
+		if runtimeWhitelist[n.String()] && !seen[n.String()] {
+			seen[n.String()] = true
+			next = append(next, n)
+		}
+	}
+	whitelist = next
 }
 
 func BlockIP(ip string, points float32) error {
+	return BlockIPReason(ip, points, "")
+}
 
-	//todo: add IP6
+// BlockIPReason is BlockIP with the reason of the ban, e.g. the rule that
+// triggered it. events are published with the ban event, only when ip is
+// newly jailed.
+func BlockIPReason(ip string, points float32, reason string, events ...notify.Event) error {
 	res := net.ParseIP(ip)
 	if res == nil {
 		return errors.New("Parameter is not an IP")
 	}
+	//normalize, so the same address has one entry in the jail
+	ip = res.String()
 
 	//check whitelist
+	if whitelisted(res) {
+		whitelistSkips.Inc()
+		voidlog.Logf("BlockIP. IP not blocked (exists in whitelist): %s \n", ip)
+		return nil
+	}
+	return addIP(ip, points, reason, events)
+}
+
+// Whitelisted reports if ip is in the whitelist
+func Whitelisted(ip string) bool {
+	res := net.ParseIP(ip)
+	return res != nil && whitelisted(res)
+}
+
+func whitelisted(ip net.IP) bool {
+	whitelistLock.RLock()
+	defer whitelistLock.RUnlock()
 	for _, net := range whitelist {
-		if net.Contains(res) {
-			voidlog.Logf("BlockIP. IP not blocked (exists in whitelist): %s \n", ip)
-			return nil
+		if net.Contains(ip) {
+			return true
 		}
 	}
-	addIP(ip, points)
-	return nil
+	return false
 }
 
-func addIP(ip string, points float32) {
+func addIP(ip string, points float32, reason string, events []notify.Event) error {
+	lock.Lock()
+	defer lock.Unlock()
+
+	if permanentBans[ip] || subnetJailed(ip) {
+		return nil
+	}
+
 	//test if IP was just added.  This can happen when several matching entries
 	//were added in the watched file at the same time.
 
 	t, ok := jailTimes[ip]
 
-	if ok {
-		fmt.Printf(" ---- %f \n", time.Now().Sub(t).Seconds())
-	}
-
-	if !ok || (time.Now().Sub(t).Seconds() > 10) {
+	if !ok || (now().Sub(t).Seconds() > 10) {
 
 		//wasn't recently added (or at all)
-		err := ipt.AppendUnique("filter", chain, "-s", ip, "-j", "DROP")
+		repeat := RepeatViolations[ip] + 1
+		if decayed(ip) {
+			repeat = 1
+		}
+
+		var permanent bool
+		points, permanent = escalate(points, repeat)
+		timeout := jailDuration(points)
+		if permanent {
+			timeout = 0
+		}
+
+		err := fw.Ban(ip, timeout)
 		if err != nil {
+			firewallErrors.Inc()
 			voidlog.Logf("Adding IP to iptables failed: %v \n", err)
-			return
+			return err
 		}
 
-		_, ok := RepeatViolations[ip]
-		if !ok {
+		if _, jailed := Ip_list[ip]; !jailed {
+			bansTotal.Inc()
+		}
+		RepeatViolations[ip] = repeat
+		recordBan(ip, points, repeat, reason)
+		switch {
+		case permanent:
+			permanentBans[ip] = true
+			savePermanent()
+			voidlog.Logf("JAILED: %s permanently. Repeated Violation #%d \n", ip, repeat)
+		case repeat == 1:
 			voidlog.Logf("JAILED: %s with %.2f points. \n", ip, points)
-			RepeatViolations[ip] = 1
-		} else {
-			RepeatViolations[ip]++
-			points = points * float32(RepeatViolations[ip])
-			voidlog.Logf("JAILED: %s with %.2f points. Repeated Violation: x%d multiplier \n", ip, points, RepeatViolations[ip])
+		case len(config.Get().Escalation) > 0:
+			voidlog.Logf("JAILED: %s with %.2f points. Repeated Violation #%d \n", ip, points, repeat)
+		default:
+			voidlog.Logf("JAILED: %s with %.2f points. Repeated Violation: x%d multiplier \n", ip, points, repeat)
+		}
+		publish(notify.Event{Type: notify.Ban, IP: ip, Points: points, Repeat: repeat,
+			Message: fmt.Sprintf("%s jailed with %.2f points", ip, points)})
+		if repeat > 1 {
+			publish(notify.Event{Type: notify.RepeatOffender, IP: ip, Points: points, Repeat: repeat,
+				Message: fmt.Sprintf("%s jailed again with %.2f points, violation #%d", ip, points, repeat)})
+		}
+		for _, e := range events {
+			publish(e)
 		}
 
 		//add to history
-		JailHistory.Value = time.Now().Format(time.Stamp) + " : " + ip
+		JailHistory.Value = now().Format(time.Stamp) + " : " + ip
 		JailHistory = JailHistory.Next()
 	} else {
-		voidlog.Logf("IP %s was already added. Increasing score to %.2f points. \n", ip, points)
+		//never lower an escalated or rule set jail time
+		if points <= Ip_list[ip] {
+			points = Ip_list[ip]
+		} else {
+			voidlog.Logf("IP %s was already added. Increasing score to %.2f points. \n", ip, points)
+
+			//extend the firewall timeout along with the score
+			err := fw.Ban(ip, jailDuration(points))
+			if err != nil {
+				firewallErrors.Inc()
+				voidlog.Logf("Extending IP ban failed: %v \n", err)
+			}
+		}
 	}
 
 	//set jail time
-	jailTimes[ip] = time.Now()
+	jailTimes[ip] = now()
+
+	Ip_list[ip] = points
+	checkSubnet(ip, points)
+	return nil
+}
+
+// BlockIPFor jails ip for exactly minutes, without the repeat violation
+// multiplier. Used for manual bans, they don't count as a repeat violation.
+func BlockIPFor(ip string, minutes float32) error {
+	res := net.ParseIP(ip)
+	if res == nil {
+		return errors.New("Parameter is not an IP")
+	}
+	ip = res.String()
+
+	if whitelisted(res) {
+		whitelistSkips.Inc()
+		return errors.New("IP is in the whitelist")
+	}
 
 	lock.Lock()
 	defer lock.Unlock()
-	Ip_list[ip] = points
 
+	//a timed firewall entry would replace the permanent or subnet one
+	if permanentBans[ip] {
+		return errors.New("IP is banned permanently")
+	}
+	if subnetJailed(ip) {
+		return errors.New("IP is in a jailed subnet")
+	}
+
+	err := fw.Ban(ip, jailDuration(minutes))
+	if err != nil {
+		firewallErrors.Inc()
+		voidlog.Logf("Adding IP to iptables failed: %v \n", err)
+		return err
+	}
+
+	if _, ok := Ip_list[ip]; !ok {
+		bansTotal.Inc()
+		recordBan(ip, minutes, RepeatViolations[ip], "manual")
+		JailHistory.Value = now().Format(time.Stamp) + " : " + ip
+		JailHistory = JailHistory.Next()
+	}
+	jailTimes[ip] = now()
+	Ip_list[ip] = minutes
+	voidlog.Logf("JAILED: %s for %.0f minutes (manual). \n", ip, minutes)
+	publish(notify.Event{Type: notify.Ban, IP: ip, Points: minutes, Repeat: RepeatViolations[ip],
+		Message: fmt.Sprintf("%s jailed for %.0f minutes (manual)", ip, minutes)})
+	return nil
+}
+
+// UnblockIP releases ip, an address or a subnet, from the jail. Repeat
+// violations are kept.
+func UnblockIP(ip string) error {
+	if res := net.ParseIP(ip); res != nil {
+		ip = res.String()
+	} else if _, ipnet, err := net.ParseCIDR(ip); err == nil {
+		ip = ipnet.String()
+	} else {
+		return errors.New("Parameter is not an IP")
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	if _, ok := Ip_list[ip]; !ok {
+		return errors.New("IP is not jailed")
+	}
+
+	err := fw.Unban(ip)
+	if err != nil {
+		firewallErrors.Inc()
+		voidlog.Logf("Delete IP from iptables failed: %v \n", err)
+		return err
+	}
+	unbansTotal.Inc()
+	delete(Ip_list, ip)
+	if permanentBans[ip] {
+		delete(permanentBans, ip)
+		savePermanent()
+	}
+	voidlog.Logf("Released IP: %s \n", ip)
+	publish(notify.Event{Type: notify.Unban, IP: ip, Message: ip + " released (manual)"})
+	return nil
+}
+
+// IPStatus returns the remaining jail points of ip and its repeat violations
+func IPStatus(ip string) (points float32, jailed bool, repeat int) {
+	lock.RLock()
+	defer lock.RUnlock()
+	points, jailed = Ip_list[ip]
+	return points, jailed, RepeatViolations[ip]
+}
+
+// History returns the jail history, newest first
+func History() []string {
+	lock.RLock()
+	defer lock.RUnlock()
+	var res []string
+	JailHistory.Do(func(p interface{}) {
+		if p != nil {
+			res = append(res, p.(string))
+		}
+	})
+	for i, j := 0, len(res)-1; i < j; i, j = i+1, j-1 {
+		res[i], res[j] = res[j], res[i]
+	}
+	return res
+}
+
+// Jail returns a copy of the jailed IPs with their remaining points
+func Jail() map[string]float32 {
+	lock.RLock()
+	defer lock.RUnlock()
+	res := make(map[string]float32, len(Ip_list))
+	for k, v := range Ip_list {
+		res[k] = v
+	}
+	return res
+}
+
+// Expiry is when an IP jailed with points will be released
+func Expiry(points float32) time.Time {
+	return time.Now().Add(time.Duration(points / decJailedPerCycle * float32(schedulerSleep)))
+}
+
+// jailDuration is how long the points keep an IP jailed, plus one cycle of slack
+// so firewall timeouts never expire before the jail releases the IP itself
+func jailDuration(points float32) time.Duration {
+	return time.Duration(points/decJailedPerCycle+1) * schedulerSleep
 }
 
 func decreaseJailTime() {
@@ -152,18 +435,30 @@ func decreaseJailTime() {
 	defer lock.Unlock()
 
 	for k, v := range Ip_list {
+		if permanentBans[k] {
+			continue
+		}
 		Ip_list[k] = v - decJailedPerCycle
 
 		if Ip_list[k] <= 0 {
-			err := ipt.Delete("filter", chain, "-s", k, "-j", "DROP")
+			err := fw.Unban(k)
 			if err != nil {
+				firewallErrors.Inc()
 				voidlog.Logf("Delete IP from iptables failed: %v \n", err)
 			}
+			unbansTotal.Inc()
 			delete(Ip_list, k)
 			voidlog.Logf("Removing IP: %s \n", k)
+			publish(notify.Event{Type: notify.Unban, IP: k, Message: k + " released"})
 		}
 	}
 
+	forgetDecayed()
+}
+
+// Tick runs one cycle of the jail scheduler, for replays on a simulated clock
+func Tick() {
+	decreaseJailTime()
 }
 
 func scheduledRemoval() {
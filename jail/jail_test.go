@@ -1,10 +1,16 @@
 package jail
 
 import (
+	"encoding/json"
 	"fmt"
+	"ipvoid/config"
+	"ipvoid/notify"
 	"math/rand"
+	"net/http"
+	"net/http/httptest"
 	"strconv"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -12,16 +18,21 @@ import (
 var x struct{}
 
 type mockFireWall struct {
+	mu         sync.Mutex //the scheduler changes the rules while the tests read them
 	blockedIPs map[string]struct{}
 }
 
 func (mf *mockFireWall) AppendUnique(table, chain string, rulespec ...string) error {
+	mf.mu.Lock()
+	defer mf.mu.Unlock()
 	fmt.Printf("IPTables (test):  -AppendUnique: %s \n", rulespec[1])
 	mf.blockedIPs[rulespec[1]] = x
 	return nil
 }
 
 func (mf *mockFireWall) Delete(table, chain string, rulespec ...string) error {
+	mf.mu.Lock()
+	defer mf.mu.Unlock()
 	fmt.Printf("IPTables (test):  -Delete: %s \n", rulespec[1])
 	delete(mf.blockedIPs, rulespec[1])
 	fmt.Printf("IPTables (test):  blocked IPs list len: %d \n", len(mf.blockedIPs))
@@ -29,23 +40,35 @@ func (mf *mockFireWall) Delete(table, chain string, rulespec ...string) error {
 }
 
 func (mf *mockFireWall) ClearChain(table, chain string) error {
+	mf.mu.Lock()
+	defer mf.mu.Unlock()
 	fmt.Printf("IPTables (test):  -ClearChain \n")
 	mf.blockedIPs = make(map[string]struct{})
 	return nil
 }
 
+// blocked reports if there is a rule for entry
+func (mf *mockFireWall) blocked(entry string) bool {
+	mf.mu.Lock()
+	defer mf.mu.Unlock()
+	_, ok := mf.blockedIPs[entry]
+	return ok
+}
+
 func newMockFireWall() *mockFireWall {
 	mf := new(mockFireWall)
 	mf.blockedIPs = make(map[string]struct{})
 	return mf
 }
 
+var mf4 = newMockFireWall()
+var mf6 = newMockFireWall()
+
 func init() {
 	//override default periods
 	schedulerSleep = time.Millisecond * 100
 
-	ipt = newMockFireWall()
-	Setup(ipt)
+	Setup(NewIPTablesFirewall(mf4, mf6))
 
 }
 
@@ -62,13 +85,13 @@ func TestJail(t *testing.T) {
 		time.Sleep(time.Millisecond * 150)
 		iter++
 	}
-	n := len(Ip_list)
+	n := len(Jail())
 	if n != 4 {
 		t.Fatalf("expected 4 elements, got %d \n", n)
 	}
 	time.Sleep(time.Second * 5)
 
-	n = len(Ip_list)
+	n = len(Jail())
 	if n != 0 {
 		t.Fatalf("expected 0 elements, got %d \n", n)
 	}
@@ -77,6 +100,29 @@ func TestJail(t *testing.T) {
 
 }
 
+func TestJailIPv6(t *testing.T) {
+	AppendWhitelist("2001:db8:1::/48")
+	BlockIP("2001:db8:1::1", 100)
+	BlockIP("2001:db8:2::1", 10)
+	BlockIP("2001:DB8:2:0::1", 10)
+
+	if n := len(Jail()); n != 1 {
+		t.Fatalf("expected 1 element, got %d \n", n)
+	}
+
+	if !mf6.blocked("2001:db8:2::1") {
+		t.Fatalf("expected IPv6 address in ip6tables \n")
+	}
+	if mf4.blocked("2001:db8:2::1") {
+		t.Fatalf("IPv6 address must not be added to iptables \n")
+	}
+
+	time.Sleep(time.Second * 2)
+	if mf6.blocked("2001:db8:2::1") {
+		t.Fatalf("expected IPv6 address to be released from ip6tables \n")
+	}
+}
+
 func randIpV4() string {
 	blocks := []string{}
 	for i := 0; i < 4; i++ {
@@ -86,3 +132,58 @@ func randIpV4() string {
 
 	return strings.Join(blocks, ".")
 }
+
+func TestSetWhitelist(t *testing.T) {
+	defer SetWhitelist(nil)
+
+	AppendWhitelist("192.0.2.0/24")
+	SetWhitelist([]string{"198.51.100.0/24"})
+	if !Whitelisted("192.0.2.1") || !Whitelisted("198.51.100.1") || !Whitelisted("127.0.0.1") {
+		t.Fatalf("unexpected whitelist after a reload: %v \n", Whitelist())
+	}
+
+	//networks dropped from the configuration go, the ones added at runtime stay
+	SetWhitelist(nil)
+	if !Whitelisted("192.0.2.1") || Whitelisted("198.51.100.1") {
+		t.Fatalf("unexpected whitelist after a second reload: %v \n", Whitelist())
+	}
+
+	RemoveWhitelist("192.0.2.0/24")
+	SetWhitelist(nil)
+	if Whitelisted("192.0.2.1") {
+		t.Fatalf("removed network back after a reload \n")
+	}
+}
+
+func TestBanEvents(t *testing.T) {
+	received := make(chan notify.Event, 10)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var e notify.Event
+		json.NewDecoder(r.Body).Decode(&e)
+		received <- e
+	}))
+	defer ts.Close()
+
+	notify.Setup([]config.Notifier{{Type: "webhook", URL: ts.URL, Events: []string{notify.GeoBlock}}})
+	defer notify.Setup(nil)
+
+	//published with the ban, not again while the IP is jailed
+	geo := notify.Event{Type: notify.GeoBlock, IP: "10.3.3.3", Country: "XX"}
+	BlockIPReason("10.3.3.3", 10, "geo-block XX", geo)
+	BlockIPReason("10.3.3.3", 10, "geo-block XX", geo)
+	defer UnblockIP("10.3.3.3")
+
+	select {
+	case e := <-received:
+		if e.IP != "10.3.3.3" || e.Country != "XX" {
+			t.Fatalf("unexpected event: %+v \n", e)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("event not published \n")
+	}
+	select {
+	case e := <-received:
+		t.Fatalf("event published again: %+v \n", e)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
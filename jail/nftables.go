@@ -0,0 +1,168 @@
+package jail
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	nftFamily = "inet"
+	nftSet4   = "jail4"
+	nftSet6   = "jail6"
+	nftNet4   = "net4"
+	nftNet6   = "net6"
+	nftList4  = "lists4"
+	nftList6  = "lists6"
+)
+
+// NFTablesFirewall keeps banned IPs in timeout sets of an nftables table,
+// so a ban is a set insert instead of a rule per IP.
+type NFTablesFirewall struct {
+	run commandRunner
+}
+
+// NewNFTablesFirewall creates an nftables backend driving the nft command.
+func NewNFTablesFirewall() *NFTablesFirewall {
+	return &NFTablesFirewall{run: execRunner{}}
+}
+
+func (f *NFTablesFirewall) nft(args ...string) error {
+	return f.run.Run("nft", args...)
+}
+
+func (f *NFTablesFirewall) Init() error {
+	//"add" is idempotent, "flush table" drops rules and set elements left by a previous run
+	cmds := [][]string{
+		{"add", "table", nftFamily, chain},
+		{"flush", "table", nftFamily, chain},
+		{"add", "set", nftFamily, chain, nftSet4, "{", "type", "ipv4_addr;", "flags", "timeout;", "}"},
+		{"add", "set", nftFamily, chain, nftSet6, "{", "type", "ipv6_addr;", "flags", "timeout;", "}"},
+		{"add", "set", nftFamily, chain, nftNet4, "{", "type", "ipv4_addr;", "flags", "interval,", "timeout;", "}"},
+		{"add", "set", nftFamily, chain, nftNet6, "{", "type", "ipv6_addr;", "flags", "interval,", "timeout;", "}"},
+		{"add", "chain", nftFamily, chain, "input", "{", "type", "filter", "hook", "input", "priority", "-10;", "policy", "accept;", "}"},
+		{"add", "rule", nftFamily, chain, "input", "ip", "saddr", "@" + nftSet4, "drop"},
+		{"add", "rule", nftFamily, chain, "input", "ip6", "saddr", "@" + nftSet6, "drop"},
+		{"add", "rule", nftFamily, chain, "input", "ip", "saddr", "@" + nftNet4, "drop"},
+		{"add", "rule", nftFamily, chain, "input", "ip6", "saddr", "@" + nftNet6, "drop"},
+	}
+
+	for _, c := range cmds {
+		err := f.nft(c...)
+		if err != nil {
+			fmt.Printf("NFTables setup issue: %v \n", err)
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *NFTablesFirewall) Clear() error {
+	for _, set := range []string{nftSet4, nftSet6, nftNet4, nftNet6} {
+		err := f.nft("flush", "set", nftFamily, chain, set)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *NFTablesFirewall) Ban(ip string, timeout time.Duration) error {
+	set, err := nftSet(ip)
+	if err != nil {
+		return err
+	}
+
+	element := []string{"{", ip, "}"}
+	if timeout > 0 {
+		element = []string{"{", ip, "timeout", fmt.Sprintf("%ds", int64(timeout.Seconds())), "}"}
+	}
+
+	//adding an existing element keeps its old timeout, so it's replaced
+	f.nft(append([]string{"delete", "element", nftFamily, chain, set}, "{", ip, "}")...)
+	return f.nft(append([]string{"add", "element", nftFamily, chain, set}, element...)...)
+}
+
+func (f *NFTablesFirewall) Unban(ip string) error {
+	set, err := nftSet(ip)
+	if err != nil {
+		return err
+	}
+	return f.nft("delete", "element", nftFamily, chain, set, "{", ip, "}")
+}
+
+func nftSet(ip string) (string, error) {
+	v4, err := isIPv4(ip)
+	if err != nil {
+		return "", err
+	}
+	switch {
+	case v4 && isCIDR(ip):
+		return nftNet4, nil
+	case v4:
+		return nftSet4, nil
+	case isCIDR(ip):
+		return nftNet6, nil
+	}
+	return nftSet6, nil
+}
+
+// InitLists adds interval sets for the imported blocklists, matched from
+// their own chain
+func (f *NFTablesFirewall) InitLists() error {
+	cmds := [][]string{
+		{"add", "set", nftFamily, chain, nftList4, "{", "type", "ipv4_addr;", "flags", "interval;", "}"},
+		{"add", "set", nftFamily, chain, nftList6, "{", "type", "ipv6_addr;", "flags", "interval;", "}"},
+		{"flush", "set", nftFamily, chain, nftList4},
+		{"flush", "set", nftFamily, chain, nftList6},
+		{"add", "chain", nftFamily, chain, "lists", "{", "type", "filter", "hook", "input", "priority", "-10;", "policy", "accept;", "}"},
+		{"flush", "chain", nftFamily, chain, "lists"},
+		{"add", "rule", nftFamily, chain, "lists", "ip", "saddr", "@" + nftList4, "drop"},
+		{"add", "rule", nftFamily, chain, "lists", "ip6", "saddr", "@" + nftList6, "drop"},
+	}
+
+	for _, c := range cmds {
+		err := f.nft(c...)
+		if err != nil {
+			fmt.Printf("NFTables setup issue: %v \n", err)
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *NFTablesFirewall) ClearLists() error {
+	for _, set := range []string{nftList4, nftList6} {
+		err := f.nft("flush", "set", nftFamily, chain, set)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *NFTablesFirewall) BanListed(ip string) error {
+	set, err := nftListSet(ip)
+	if err != nil {
+		return err
+	}
+	return f.nft("add", "element", nftFamily, chain, set, "{", ip, "}")
+}
+
+func (f *NFTablesFirewall) UnbanListed(ip string) error {
+	set, err := nftListSet(ip)
+	if err != nil {
+		return err
+	}
+	return f.nft("delete", "element", nftFamily, chain, set, "{", ip, "}")
+}
+
+func nftListSet(ip string) (string, error) {
+	v4, err := isIPv4(ip)
+	if err != nil {
+		return "", err
+	}
+	if v4 {
+		return nftList4, nil
+	}
+	return nftList6, nil
+}
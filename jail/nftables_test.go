@@ -0,0 +1,130 @@
+package jail
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+type mockRunner struct {
+	cmds     []string
+	elements map[string]struct{}
+}
+
+func (mr *mockRunner) Run(name string, args ...string) error {
+	cmd := name + " " + strings.Join(args, " ")
+	mr.cmds = append(mr.cmds, cmd)
+
+	if len(args) > 1 && args[1] == "element" {
+		key := args[4] + " " + args[6]
+		_, exists := mr.elements[key]
+		switch args[0] {
+		case "add":
+			//like nft, interval sets refuse overlapping elements
+			if strings.HasPrefix(args[4], "lists") && overlaps(mr.elements, args[4], args[6]) {
+				return errors.New("conflicting intervals specified")
+			}
+			mr.elements[key] = x
+		case "delete":
+			if !exists {
+				return errors.New("No such file or directory")
+			}
+			delete(mr.elements, key)
+		}
+	}
+	if len(args) > 1 && args[0] == "flush" {
+		mr.elements = make(map[string]struct{})
+	}
+	return nil
+}
+
+// overlaps reports if e overlaps an element of set other than itself
+func overlaps(elements map[string]struct{}, set string, e string) bool {
+	_, n, _ := parseEntry(e)
+	for key := range elements {
+		parts := strings.Fields(key)
+		if parts[0] != set || parts[1] == e {
+			continue
+		}
+		_, m, _ := parseEntry(parts[1])
+		if n.Contains(m.IP) || m.Contains(n.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+func newMockRunner() *mockRunner {
+	return &mockRunner{elements: make(map[string]struct{})}
+}
+
+func TestNFTables(t *testing.T) {
+	mr := newMockRunner()
+	f := &NFTablesFirewall{run: mr}
+
+	err := f.Init()
+	if err != nil {
+		t.Fatalf("Init failed: %s \n", err.Error())
+	}
+
+	f.Ban("10.0.0.1", 10*time.Minute)
+	f.Ban("2001:db8::1", 0)
+	f.Ban("10.0.0.1", 20*time.Minute)
+
+	if _, ok := mr.elements["jail4 10.0.0.1"]; !ok {
+		t.Fatalf("expected 10.0.0.1 in jail4, got %v \n", mr.elements)
+	}
+	if _, ok := mr.elements["jail6 2001:db8::1"]; !ok {
+		t.Fatalf("expected 2001:db8::1 in jail6, got %v \n", mr.elements)
+	}
+
+	last := mr.cmds[len(mr.cmds)-1]
+	if last != "nft add element inet ipvoid jail4 { 10.0.0.1 timeout 1200s }" {
+		t.Fatalf("unexpected command: %s \n", last)
+	}
+
+	f.Ban("2001:db8:1::/64", 0)
+	if _, ok := mr.elements["net6 2001:db8:1::/64"]; !ok {
+		t.Fatalf("expected 2001:db8:1::/64 in net6, got %v \n", mr.elements)
+	}
+	f.Unban("2001:db8:1::/64")
+
+	err = f.Unban("10.0.0.1")
+	if err != nil || len(mr.elements) != 1 {
+		t.Fatalf("Unban failed: %v %v \n", err, mr.elements)
+	}
+
+	if f.Ban("not an ip", 0) == nil {
+		t.Fatalf("expected error for invalid IP \n")
+	}
+
+	f.Clear()
+	if len(mr.elements) != 0 {
+		t.Fatalf("expected empty sets, got %v \n", mr.elements)
+	}
+}
+
+func TestNFTablesLists(t *testing.T) {
+	mr := newMockRunner()
+	f := &NFTablesFirewall{run: mr}
+
+	err := f.InitLists()
+	if err != nil {
+		t.Fatalf("InitLists failed: %s \n", err.Error())
+	}
+
+	f.BanListed("198.51.100.0/24")
+	f.BanListed("2001:db8::/32")
+	if _, ok := mr.elements["lists4 198.51.100.0/24"]; !ok {
+		t.Fatalf("expected 198.51.100.0/24 in lists4, got %v \n", mr.elements)
+	}
+	if _, ok := mr.elements["lists6 2001:db8::/32"]; !ok {
+		t.Fatalf("expected 2001:db8::/32 in lists6, got %v \n", mr.elements)
+	}
+
+	err = f.UnbanListed("198.51.100.0/24")
+	if err != nil || len(mr.elements) != 1 {
+		t.Fatalf("UnbanListed failed: %v %v \n", err, mr.elements)
+	}
+}
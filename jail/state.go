@@ -0,0 +1,139 @@
+package jail
+
+import (
+	"encoding/gob"
+	"log"
+	"net"
+	"os"
+	"time"
+)
+
+var statedir = "state"
+
+// state is the jail as saved on shutdown
+type state struct {
+	IpList           map[string]float32
+	RepeatViolations map[string]int
+	JailTimes        map[string]time.Time
+	History          []string
+	Permanent        []string
+	Saved            time.Time
+}
+
+// StoreState saves jailed IPs with their remaining time, repeat violations
+// and the jail history, so a restart doesn't release or forgive anyone.
+// A dry run leaves the saved state alone.
+func StoreState() {
+	if dryRun {
+		return
+	}
+
+	if _, err := os.Stat(statedir); os.IsNotExist(err) {
+		os.MkdirAll(statedir, 0755)
+	}
+
+	//written aside and renamed, a crash while saving keeps the last state
+	path := statedir + "/jail"
+	tmp := path + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		log.Println("Couldn't save jail state: File create failed. " + err.Error())
+		return
+	}
+
+	lock.RLock()
+	st := state{
+		IpList:           Ip_list,
+		RepeatViolations: RepeatViolations,
+		JailTimes:        jailTimes,
+		Saved:            time.Now(),
+	}
+	JailHistory.Do(func(p interface{}) {
+		if p != nil {
+			st.History = append(st.History, p.(string))
+		}
+	})
+	for ip := range permanentBans {
+		st.Permanent = append(st.Permanent, ip)
+	}
+
+	encoder := gob.NewEncoder(file)
+	err = encoder.Encode(st)
+	lock.RUnlock()
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		log.Println("Couldn't save jail state: " + err.Error())
+	}
+}
+
+// loadState restores the saved jail. The time spent offline counts
+// towards the jail time.
+func loadState() {
+	file, err := os.Open(statedir + "/jail")
+	if err != nil {
+		return
+	}
+	defer file.Close()
+
+	st := state{}
+	decoder := gob.NewDecoder(file)
+	err = decoder.Decode(&st)
+	if err != nil {
+		log.Println("Couldn't load jail state " + err.Error())
+		return
+	}
+
+	for ip, count := range st.RepeatViolations {
+		RepeatViolations[ip] = count
+	}
+	for ip, t := range st.JailTimes {
+		jailTimes[ip] = t
+	}
+	for _, ip := range st.Permanent {
+		permanentBans[ip] = true
+	}
+	for _, h := range st.History {
+		JailHistory.Value = h
+		JailHistory = JailHistory.Next()
+	}
+
+	offline := float32(time.Since(st.Saved)/schedulerSleep) * decJailedPerCycle
+
+	lock.Lock()
+	defer lock.Unlock()
+	for ip, points := range st.IpList {
+		if permanentBans[ip] {
+			//banned without timeout by loadPermanent
+			Ip_list[ip] = points
+			continue
+		}
+
+		points -= offline
+		if points <= 0 || whitelisted(net.ParseIP(ip)) {
+			continue
+		}
+
+		err := fw.Ban(ip, jailDuration(points))
+		if err != nil {
+			log.Printf("Couldn't restore ban of %s: %v \n", ip, err)
+			continue
+		}
+		Ip_list[ip] = points
+	}
+
+	log.Printf("Jail state loaded: %d IPs jailed \n", len(Ip_list))
+}
+
+// Jailed reports if ip is currently in the jail
+func Jailed(ip string) bool {
+	lock.RLock()
+	defer lock.RUnlock()
+	_, ok := Ip_list[ip]
+	return ok
+}
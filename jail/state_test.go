@@ -0,0 +1,67 @@
+package jail
+
+import (
+	"container/ring"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestState(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jail")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	statedir = dir
+	defer func() { statedir = "state" }()
+
+	BlockIP("10.9.9.9", 1000)
+	BlockIP("10.9.9.8", 1000)
+	lock.Lock()
+	RepeatViolations["10.9.9.8"] = 3
+	lock.Unlock()
+	StoreState()
+	if _, err := os.Stat(dir + "/jail.tmp"); !os.IsNotExist(err) {
+		t.Fatalf("temporary state file left behind \n")
+	}
+
+	lock.Lock()
+	delete(Ip_list, "10.9.9.9")
+	delete(Ip_list, "10.9.9.8")
+	delete(RepeatViolations, "10.9.9.9")
+	delete(RepeatViolations, "10.9.9.8")
+	lock.Unlock()
+	JailHistory = ring.New(1024)
+	ClearJail()
+
+	loadState()
+
+	if !Jailed("10.9.9.9") || !Jailed("10.9.9.8") {
+		t.Fatalf("jailed IPs not restored: %v \n", Jail())
+	}
+	_, _, repeat8 := IPStatus("10.9.9.8")
+	_, _, repeat9 := IPStatus("10.9.9.9")
+	if repeat8 != 3 || repeat9 != 1 {
+		t.Fatalf("repeat violations not restored: %d %d \n", repeat8, repeat9)
+	}
+	if !mf4.blocked("10.9.9.9") {
+		t.Fatalf("restored IP not banned in the firewall \n")
+	}
+
+	found := false
+	JailHistory.Do(func(p interface{}) {
+		if p != nil && strings.HasSuffix(p.(string), "10.9.9.8") {
+			found = true
+		}
+	})
+	if !found {
+		t.Fatalf("jail history not restored \n")
+	}
+
+	lock.Lock()
+	delete(Ip_list, "10.9.9.9")
+	delete(Ip_list, "10.9.9.8")
+	lock.Unlock()
+}
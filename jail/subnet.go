@@ -0,0 +1,141 @@
+package jail
+
+import (
+	"errors"
+	"fmt"
+	"ipvoid/config"
+	"ipvoid/notify"
+	"ipvoid/voidlog"
+	"net"
+	"time"
+)
+
+// Subnet returns the CIDR of ip at the configured aggregation prefix length,
+// or "" when subnet aggregation is off for its IP family
+func Subnet(ip string) string {
+	res := net.ParseIP(ip)
+	if res == nil {
+		return ""
+	}
+
+	bits, prefix := 128, config.Get().SubnetPrefixV6
+	if res.To4() != nil {
+		res = res.To4()
+		bits, prefix = 32, config.Get().SubnetPrefixV4
+	}
+	if prefix <= 0 || prefix >= bits {
+		return ""
+	}
+
+	mask := net.CIDRMask(prefix, bits)
+	return (&net.IPNet{IP: res.Mask(mask), Mask: mask}).String()
+}
+
+// BlockSubnet jails a whole subnet. Jailed IPs of the subnet are released
+// from their own firewall rules, the subnet serves their longest jail time.
+func BlockSubnet(cidr string, points float32) error {
+	_, ipnet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return errors.New("Parameter is not in a CIDR notation")
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+	return blockSubnet(ipnet, points)
+}
+
+// blockSubnet is called with lock held
+func blockSubnet(ipnet *net.IPNet, points float32) error {
+	cidr := ipnet.String()
+	if whitelistOverlaps(ipnet) {
+		whitelistSkips.Inc()
+		voidlog.Logf("Subnet not blocked (overlaps the whitelist): %s \n", cidr)
+		return nil
+	}
+
+	var collapsed []string
+	for ip, p := range Ip_list {
+		if isCIDR(ip) || permanentBans[ip] || !ipnet.Contains(net.ParseIP(ip)) {
+			continue
+		}
+		collapsed = append(collapsed, ip)
+		if p > points {
+			points = p
+		}
+	}
+
+	current, jailed := Ip_list[cidr]
+	if jailed && current >= points && len(collapsed) == 0 {
+		return nil
+	}
+
+	err := fw.Ban(cidr, jailDuration(points))
+	if err != nil {
+		firewallErrors.Inc()
+		voidlog.Logf("Adding subnet to the firewall failed: %v \n", err)
+		return err
+	}
+	Ip_list[cidr] = points
+
+	if !jailed {
+		bansTotal.Inc()
+		recordBan(cidr, points, 0, fmt.Sprintf("subnet, %d IPs", len(collapsed)))
+		voidlog.Logf("JAILED: subnet %s with %.2f points, replacing %d IP rules. \n", cidr, points, len(collapsed))
+		publish(notify.Event{Type: notify.Ban, IP: cidr, Points: points,
+			Message: fmt.Sprintf("subnet %s jailed with %.2f points", cidr, points)})
+		JailHistory.Value = now().Format(time.Stamp) + " : " + cidr
+		JailHistory = JailHistory.Next()
+	}
+
+	for _, ip := range collapsed {
+		err := fw.Unban(ip)
+		if err != nil {
+			firewallErrors.Inc()
+			voidlog.Logf("Delete IP from iptables failed: %v \n", err)
+		}
+		delete(Ip_list, ip)
+	}
+	return nil
+}
+
+// checkSubnet jails the subnet of ip when enough of its IPs are jailed.
+// Called with lock held.
+func checkSubnet(ip string, points float32) {
+	threshold := config.Get().SubnetJailedThreshold
+	subnet := Subnet(ip)
+	if threshold <= 0 || subnet == "" {
+		return
+	}
+
+	_, ipnet, _ := net.ParseCIDR(subnet)
+	count := 0
+	for jailed := range Ip_list {
+		if !isCIDR(jailed) && ipnet.Contains(net.ParseIP(jailed)) {
+			count++
+		}
+	}
+	if count >= threshold {
+		blockSubnet(ipnet, points)
+	}
+}
+
+// subnetJailed reports if ip is covered by a jailed subnet. Called with lock held.
+func subnetJailed(ip string) bool {
+	subnet := Subnet(ip)
+	if subnet == "" {
+		return false
+	}
+	_, ok := Ip_list[subnet]
+	return ok
+}
+
+func whitelistOverlaps(ipnet *net.IPNet) bool {
+	whitelistLock.RLock()
+	defer whitelistLock.RUnlock()
+	for _, n := range whitelist {
+		if n.Contains(ipnet.IP) || ipnet.Contains(n.IP) {
+			return true
+		}
+	}
+	return false
+}
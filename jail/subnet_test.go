@@ -0,0 +1,85 @@
+package jail
+
+import (
+	"ipvoid/config"
+	"testing"
+)
+
+func TestSubnet(t *testing.T) {
+	defer config.Set(config.Get())
+	conf := *config.Get()
+	conf.SubnetPrefixV4 = 24
+	conf.SubnetPrefixV6 = 64
+	config.Set(&conf)
+
+	subnets := map[string]string{
+		"10.6.6.7":          "10.6.6.0/24",
+		"2001:db8:1:2:3::4": "2001:db8:1:2::/64",
+		"::ffff:10.6.6.7":   "10.6.6.0/24",
+		"not an ip":         "",
+	}
+	for ip, subnet := range subnets {
+		if res := Subnet(ip); res != subnet {
+			t.Fatalf("%s: expected %q, got %q \n", ip, subnet, res)
+		}
+	}
+
+	off := conf
+	off.SubnetPrefixV4 = 0
+	config.Set(&off)
+	if res := Subnet("10.6.6.7"); res != "" {
+		t.Fatalf("expected no subnet with aggregation off, got %q \n", res)
+	}
+}
+
+func TestSubnetCollapse(t *testing.T) {
+	defer config.Set(config.Get())
+	conf := *config.Get()
+	conf.SubnetPrefixV4 = 24
+	conf.SubnetJailedThreshold = 3
+	config.Set(&conf)
+
+	BlockIP("10.6.6.1", 100)
+	BlockIP("10.6.6.2", 200)
+	if Jailed("10.6.6.0/24") {
+		t.Fatalf("subnet jailed below the threshold \n")
+	}
+
+	BlockIP("10.6.6.3", 100)
+	points, jailed, _ := IPStatus("10.6.6.0/24")
+	if !jailed || points != 200 {
+		t.Fatalf("expected the subnet jailed with 200 points, got %.2f (jailed %v) \n", points, jailed)
+	}
+	if !mf4.blocked("10.6.6.0/24") {
+		t.Fatalf("subnet not banned in the firewall \n")
+	}
+	for _, ip := range []string{"10.6.6.1", "10.6.6.2", "10.6.6.3"} {
+		if mf4.blocked(ip) || Jailed(ip) {
+			t.Fatalf("%s not collapsed into the subnet \n", ip)
+		}
+	}
+
+	//IPs of a jailed subnet don't get their own rule
+	BlockIP("10.6.6.4", 100)
+	if Jailed("10.6.6.4") {
+		t.Fatalf("IP of a jailed subnet jailed on its own \n")
+	}
+	if err := BlockIPFor("10.6.6.4", 10); err == nil || Jailed("10.6.6.4") {
+		t.Fatalf("manual ban of an IP of a jailed subnet \n")
+	}
+
+	err := UnblockIP("10.6.6.0/24")
+	if err != nil || Jailed("10.6.6.0/24") {
+		t.Fatalf("subnet not released: %v \n", err)
+	}
+}
+
+func TestSubnetWhitelist(t *testing.T) {
+	AppendWhitelist("10.5.5.5/32")
+	defer RemoveWhitelist("10.5.5.5/32")
+
+	BlockSubnet("10.5.5.0/24", 100)
+	if Jailed("10.5.5.0/24") {
+		t.Fatalf("subnet overlapping the whitelist jailed \n")
+	}
+}
@@ -0,0 +1,198 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// metric is anything that can write itself in the Prometheus text format
+type metric interface {
+	name() string
+	write(w io.Writer)
+}
+
+var registry = struct {
+	sync.Mutex
+	metrics map[string]metric
+}{metrics: make(map[string]metric)}
+
+func register(m metric) {
+	registry.Lock()
+	defer registry.Unlock()
+	if _, ok := registry.metrics[m.name()]; ok {
+		panic("metrics: duplicate metric " + m.name())
+	}
+	registry.metrics[m.name()] = m
+}
+
+// Counter is a monotonically increasing value
+type Counter struct {
+	value uint64
+}
+
+func (c *Counter) Inc() {
+	atomic.AddUint64(&c.value, 1)
+}
+
+func (c *Counter) Add(n uint64) {
+	atomic.AddUint64(&c.value, n)
+}
+
+func (c *Counter) Value() uint64 {
+	return atomic.LoadUint64(&c.value)
+}
+
+type counter struct {
+	Counter
+	n, help string
+}
+
+func (c *counter) name() string { return c.n }
+
+func (c *counter) write(w io.Writer) {
+	header(w, c.n, c.help, "counter")
+	fmt.Fprintf(w, "%s %d\n", c.n, c.Value())
+}
+
+// NewCounter registers a counter
+func NewCounter(name, help string) *Counter {
+	c := &counter{n: name, help: help}
+	register(c)
+	return &c.Counter
+}
+
+// CounterVec is a set of counters told apart by the values of their labels
+type CounterVec struct {
+	n, help  string
+	labels   []string
+	lock     sync.RWMutex
+	counters map[string]*Counter //label values joined by labelSep
+}
+
+const labelSep = "\xff"
+
+// NewCounterVec registers a counter with labels
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	cv := &CounterVec{n: name, help: help, labels: labels, counters: make(map[string]*Counter)}
+	register(cv)
+	return cv
+}
+
+// With returns the counter of label values, given in the order of the labels
+func (cv *CounterVec) With(values ...string) *Counter {
+	value := strings.Join(values, labelSep)
+	cv.lock.RLock()
+	c, ok := cv.counters[value]
+	cv.lock.RUnlock()
+	if ok {
+		return c
+	}
+
+	cv.lock.Lock()
+	defer cv.lock.Unlock()
+	if c, ok = cv.counters[value]; !ok {
+		c = &Counter{}
+		cv.counters[value] = c
+	}
+	return c
+}
+
+func (cv *CounterVec) name() string { return cv.n }
+
+func (cv *CounterVec) write(w io.Writer) {
+	header(w, cv.n, cv.help, "counter")
+
+	cv.lock.RLock()
+	values := make([]string, 0, len(cv.counters))
+	for v := range cv.counters {
+		values = append(values, v)
+	}
+	cv.lock.RUnlock()
+	sort.Strings(values)
+
+	for _, v := range values {
+		pairs := make([]string, len(cv.labels))
+		for i, lv := range strings.Split(v, labelSep) {
+			if i < len(pairs) {
+				pairs[i] = fmt.Sprintf("%s=\"%s\"", cv.labels[i], escape(lv))
+			}
+		}
+		cv.lock.RLock()
+		c := cv.counters[v]
+		cv.lock.RUnlock()
+		fmt.Fprintf(w, "%s{%s} %d\n", cv.n, strings.Join(pairs, ","), c.Value())
+	}
+}
+
+// gaugeFunc is a gauge read when metrics are collected
+type gaugeFunc struct {
+	n, help string
+	f       func() float64
+}
+
+// NewGaugeFunc registers a gauge whose value is returned by f
+func NewGaugeFunc(name, help string, f func() float64) {
+	register(&gaugeFunc{name, help, f})
+}
+
+func (g *gaugeFunc) name() string { return g.n }
+
+func (g *gaugeFunc) write(w io.Writer) {
+	header(w, g.n, g.help, "gauge")
+	fmt.Fprintf(w, "%s %s\n", g.n, formatFloat(g.f()))
+}
+
+func header(w io.Writer, name, help, typ string) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, typ)
+}
+
+func escape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`).Replace(s)
+}
+
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	case math.IsNaN(f):
+		return "NaN"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// WriteText writes all metrics in the Prometheus text format
+func WriteText(w io.Writer) {
+	registry.Lock()
+	names := make([]string, 0, len(registry.metrics))
+	for n := range registry.metrics {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	metrics := make([]metric, 0, len(names))
+	for _, n := range names {
+		metrics = append(metrics, registry.metrics[n])
+	}
+	registry.Unlock()
+
+	for _, m := range metrics {
+		m.write(w)
+	}
+}
+
+// Handler serves the metrics for Prometheus
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		WriteText(w)
+	})
+}
@@ -0,0 +1,41 @@
+package metrics
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestWriteText(t *testing.T) {
+	c := NewCounter("test_lines_total", "Lines read.")
+	cv := NewCounterVec("test_matches_total", "Rule matches.", "rule")
+	cv2 := NewCounterVec("test_rule_matches_total", "Rule matches per source.", "source", "rule")
+	NewGaugeFunc("test_size", "Size of something.", func() float64 { return 2.5 })
+
+	c.Inc()
+	c.Add(2)
+	cv.With("php").Inc()
+	cv.With(`a"b`).Add(4)
+	cv2.With("access.log", "line1").Inc()
+	cv2.With("mail.log", "line1").Add(2)
+
+	var b bytes.Buffer
+	WriteText(&b)
+	out := b.String()
+
+	expected := []string{
+		"# HELP test_lines_total Lines read.\n# TYPE test_lines_total counter\ntest_lines_total 3\n",
+		"# TYPE test_matches_total counter\ntest_matches_total{rule=\"a\\\"b\"} 4\ntest_matches_total{rule=\"php\"} 1\n",
+		"test_rule_matches_total{source=\"access.log\",rule=\"line1\"} 1\ntest_rule_matches_total{source=\"mail.log\",rule=\"line1\"} 2\n",
+		"# TYPE test_size gauge\ntest_size 2.5\n",
+	}
+	for _, e := range expected {
+		if !strings.Contains(out, e) {
+			t.Fatalf("expected %q in:\n%s", e, out)
+		}
+	}
+
+	if strings.Index(out, "test_lines_total") > strings.Index(out, "test_size") {
+		t.Fatalf("metrics are not sorted by name \n")
+	}
+}
@@ -0,0 +1,163 @@
+package notify
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"ipvoid/config"
+	"log"
+	"sync"
+	"time"
+)
+
+// Event types
+const (
+	Ban            = "ban"
+	Unban          = "unban"
+	RepeatOffender = "repeat-offender"
+	GeoBlock       = "geo-block"
+	ASNBlock       = "asn-block"
+)
+
+const defaultQueueSize = 100
+const defaultRetries = 3
+
+// Event is something that happened in the jail
+type Event struct {
+	Type    string    `json:"type"`
+	IP      string    `json:"ip"`
+	Points  float32   `json:"points,omitempty"`
+	Repeat  int       `json:"repeat,omitempty"`
+	Country string    `json:"country,omitempty"`
+	ASN     int       `json:"asn,omitempty"`
+	Time    time.Time `json:"time"`
+	Message string    `json:"message"`
+}
+
+// Sink delivers events somewhere. Sinks holding a connection implement
+// io.Closer, they are closed once replaced and their queue is delivered.
+type Sink interface {
+	Send(e Event) error
+}
+
+// notifier queues events for one sink, so a slow sink doesn't hold up the others
+type notifier struct {
+	name    string
+	sink    Sink
+	events  map[string]bool //empty means all
+	retries int
+	queue   chan Event
+}
+
+var notifiers []*notifier
+var lock = sync.RWMutex{}
+var retryDelay = time.Second
+
+// Setup replaces the notifiers with the configured ones. Events still queued
+// for the old notifiers are delivered.
+func Setup(cfgs []config.Notifier) error {
+	ns := make([]*notifier, 0, len(cfgs))
+	for i, cfg := range cfgs {
+		sink, err := newSink(cfg)
+		if err != nil {
+			for _, n := range ns {
+				closeSink(n.sink)
+			}
+			return fmt.Errorf("notifier %d: %s", i+1, err.Error())
+		}
+		ns = append(ns, newNotifier(cfg.Type, sink, cfg))
+	}
+
+	lock.Lock()
+	old := notifiers
+	notifiers = ns
+	lock.Unlock()
+
+	for _, n := range old {
+		close(n.queue)
+	}
+	for _, n := range ns {
+		go n.run()
+	}
+	return nil
+}
+
+func newSink(cfg config.Notifier) (Sink, error) {
+	for _, e := range cfg.Events {
+		if e != Ban && e != Unban && e != RepeatOffender && e != GeoBlock && e != ASNBlock {
+			return nil, errors.New("unknown event type: " + e)
+		}
+	}
+
+	switch cfg.Type {
+	case "webhook":
+		return newWebhook(cfg)
+	case "smtp":
+		return newSMTP(cfg)
+	case "syslog":
+		return newSyslog(cfg)
+	}
+	return nil, errors.New("unknown notifier type: " + cfg.Type)
+}
+
+func newNotifier(name string, sink Sink, cfg config.Notifier) *notifier {
+	n := &notifier{name: name, sink: sink, events: make(map[string]bool), retries: defaultRetries}
+	for _, e := range cfg.Events {
+		n.events[e] = true
+	}
+	if cfg.Retries != nil {
+		n.retries = *cfg.Retries
+	}
+	size := cfg.QueueSize
+	if size <= 0 {
+		size = defaultQueueSize
+	}
+	n.queue = make(chan Event, size)
+	return n
+}
+
+// Publish queues e for the notifiers interested in its type. It never blocks:
+// when a queue is full the event is dropped for that notifier.
+func Publish(e Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	lock.RLock()
+	defer lock.RUnlock()
+	for _, n := range notifiers {
+		if len(n.events) > 0 && !n.events[e.Type] {
+			continue
+		}
+		select {
+		case n.queue <- e:
+		default:
+			log.Printf("Notifier %s: queue full, %s event for %s dropped \n", n.name, e.Type, e.IP)
+		}
+	}
+}
+
+func (n *notifier) run() {
+	defer closeSink(n.sink)
+	for e := range n.queue {
+		delay := retryDelay
+		for attempt := 0; ; attempt++ {
+			err := n.sink.Send(e)
+			if err == nil {
+				break
+			}
+			if attempt >= n.retries {
+				log.Printf("Notifier %s: %s event for %s not sent: %s \n", n.name, e.Type, e.IP, err.Error())
+				break
+			}
+			time.Sleep(delay)
+			delay *= 2
+		}
+	}
+}
+
+func closeSink(sink Sink) {
+	if c, ok := sink.(io.Closer); ok {
+		c.Close()
+	}
+}
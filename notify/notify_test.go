@@ -0,0 +1,151 @@
+package notify
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"ipvoid/config"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestWebhook(t *testing.T) {
+	retryDelay = time.Millisecond
+	received := make(chan Event, 10)
+	calls := 0
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		body, _ := ioutil.ReadAll(r.Body)
+
+		mac := hmac.New(sha256.New, []byte("secret"))
+		mac.Write(body)
+		if r.Header.Get(SignatureHeader) != "sha256="+hex.EncodeToString(mac.Sum(nil)) {
+			t.Errorf("bad signature: %s \n", r.Header.Get(SignatureHeader))
+		}
+
+		//first attempt fails, so the event has to be retried
+		if calls == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		var e Event
+		if err := json.Unmarshal(body, &e); err != nil {
+			t.Errorf("bad JSON: %s \n", err.Error())
+		}
+		received <- e
+	}))
+	defer ts.Close()
+
+	err := Setup([]config.Notifier{{Type: "webhook", URL: ts.URL, Secret: "secret", Events: []string{Ban}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer Setup(nil)
+
+	Publish(Event{Type: Unban, IP: "192.0.2.1"})
+	Publish(Event{Type: Ban, IP: "192.0.2.2", Points: 100})
+
+	select {
+	case e := <-received:
+		if e.Type != Ban || e.IP != "192.0.2.2" || e.Points != 100 || e.Time.IsZero() {
+			t.Fatalf("unexpected event: %+v \n", e)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("event not delivered \n")
+	}
+
+	select {
+	case e := <-received:
+		t.Fatalf("filtered event delivered: %+v \n", e)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+type blockingSink struct {
+	release chan bool
+}
+
+func (b *blockingSink) Send(e Event) error {
+	<-b.release
+	return nil
+}
+
+func TestQueueFull(t *testing.T) {
+	sink := &blockingSink{make(chan bool)}
+	n := newNotifier("test", sink, config.Notifier{QueueSize: 2})
+
+	lock.Lock()
+	notifiers = []*notifier{n}
+	lock.Unlock()
+	defer Setup(nil)
+
+	//none of these may block, the ones over the queue size are dropped
+	for i := 0; i < 5; i++ {
+		Publish(Event{Type: Ban, IP: "192.0.2.1"})
+	}
+	if len(n.queue) != 2 {
+		t.Fatalf("expected 2 queued events, got %d \n", len(n.queue))
+	}
+}
+
+// failingSink fails every delivery and counts the attempts
+type failingSink struct {
+	attempts chan bool
+	closed   chan bool
+}
+
+func (f *failingSink) Send(e Event) error {
+	f.attempts <- true
+	return errors.New("unreachable")
+}
+
+func (f *failingSink) Close() error {
+	close(f.closed)
+	return nil
+}
+
+func TestRetriesOff(t *testing.T) {
+	retryDelay = time.Millisecond
+	sink := &failingSink{make(chan bool, 10), make(chan bool)}
+	retries := 0
+	n := newNotifier("test", sink, config.Notifier{Retries: &retries})
+
+	lock.Lock()
+	notifiers = []*notifier{n}
+	lock.Unlock()
+	go n.run()
+
+	Publish(Event{Type: Ban, IP: "192.0.2.1"})
+	Setup(nil)
+
+	//the replaced notifier is closed once its queue is delivered
+	select {
+	case <-sink.closed:
+	case <-time.After(time.Second):
+		t.Fatalf("replaced sink not closed \n")
+	}
+	if len(sink.attempts) != 1 {
+		t.Fatalf("expected a single attempt, got %d \n", len(sink.attempts))
+	}
+}
+
+func TestSetupErrors(t *testing.T) {
+	bad := [][]config.Notifier{
+		{{Type: "pager"}},
+		{{Type: "webhook"}},
+		{{Type: "smtp", SMTPServer: "localhost:25"}},
+		{{Type: "webhook", URL: "http://localhost/", Events: []string{"jailed"}}},
+	}
+	for _, cfgs := range bad {
+		if Setup(cfgs) == nil {
+			t.Fatalf("expected an error for %+v \n", cfgs)
+		}
+	}
+}
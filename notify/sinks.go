@@ -0,0 +1,106 @@
+package notify
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"ipvoid/config"
+	"log/syslog"
+	"net/http"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// SignatureHeader carries the hex HMAC-SHA256 of the webhook body, keyed
+// with the notifier Secret
+const SignatureHeader = "X-Ipvoid-Signature"
+
+type webhook struct {
+	url    string
+	secret []byte
+	client *http.Client
+}
+
+func newWebhook(cfg config.Notifier) (Sink, error) {
+	if cfg.URL == "" {
+		return nil, errors.New("webhook without URL")
+	}
+	return &webhook{cfg.URL, []byte(cfg.Secret), &http.Client{Timeout: 10 * time.Second}}, nil
+}
+
+func (w *webhook) Send(e Event) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if len(w.secret) > 0 {
+		mac := hmac.New(sha256.New, w.secret)
+		mac.Write(body)
+		req.Header.Set(SignatureHeader, "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+type mail struct {
+	server string
+	from   string
+	to     []string
+}
+
+func newSMTP(cfg config.Notifier) (Sink, error) {
+	if cfg.SMTPServer == "" || cfg.From == "" || len(cfg.To) == 0 {
+		return nil, errors.New("smtp needs SMTPServer, From and To")
+	}
+	return &mail{cfg.SMTPServer, cfg.From, cfg.To}, nil
+}
+
+func (m *mail) Send(e Event) error {
+	msg := "From: " + m.from + "\r\n" +
+		"To: " + strings.Join(m.to, ", ") + "\r\n" +
+		"Subject: ipvoid " + e.Type + " " + e.IP + "\r\n" +
+		"Date: " + e.Time.Format(time.RFC1123Z) + "\r\n" +
+		"\r\n" + e.Message + "\r\n"
+
+	//local relay, no authentication
+	return smtp.SendMail(m.server, nil, m.from, m.to, []byte(msg))
+}
+
+type sysLog struct {
+	w *syslog.Writer
+}
+
+func newSyslog(cfg config.Notifier) (Sink, error) {
+	w, err := syslog.New(syslog.LOG_NOTICE|syslog.LOG_DAEMON, "ipvoid")
+	if err != nil {
+		return nil, err
+	}
+	return &sysLog{w}, nil
+}
+
+func (s *sysLog) Send(e Event) error {
+	return s.w.Notice(e.Message)
+}
+
+func (s *sysLog) Close() error {
+	return s.w.Close()
+}
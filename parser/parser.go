@@ -0,0 +1,176 @@
+package parser
+
+import (
+	"encoding/json"
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// IPField is the field holding the client IP of a parsed line
+const IPField = "ip"
+
+// Parser splits a log line into named fields.
+type Parser interface {
+	Parse(line string) (map[string]string, error)
+}
+
+var ErrNoMatch = errors.New("line doesn't match the log format")
+
+// New returns the parser of a log format: "common" / "combined" (Apache and
+// nginx access logs), "json" (one object per line) or "syslog" (RFC 5424 and
+// RFC 3164). Raw lines ("" or "raw") have no parser.
+// ipField is the JSON key holding the client IP.
+func New(format string, ipField string) (Parser, error) {
+	switch format {
+	case "", "raw":
+		return nil, nil
+	case "common", "combined":
+		return &clfParser{}, nil
+	case "json":
+		if ipField == "" {
+			ipField = IPField
+		}
+		return &jsonParser{ipField}, nil
+	case "syslog":
+		return &syslogParser{}, nil
+	}
+	return nil, errors.New("unknown log format: " + format)
+}
+
+// Common Log Format, with the optional referer and user agent of the Combined format
+var clfRegEx = regexp.MustCompile(`^(\S+) (\S+) (\S+) \[([^\]]+)\] "((?:[^"\\]|\\.)*)" (\d{3}|-) (\S+)(?: "((?:[^"\\]|\\.)*)" "((?:[^"\\]|\\.)*)")?`)
+
+type clfParser struct{}
+
+func (p *clfParser) Parse(line string) (map[string]string, error) {
+	m := clfRegEx.FindStringSubmatch(strings.TrimRight(line, "\r\n"))
+	if m == nil {
+		return nil, ErrNoMatch
+	}
+
+	fields := map[string]string{
+		IPField:   m[1],
+		"ident":   m[2],
+		"user":    m[3],
+		"time":    m[4],
+		"request": m[5],
+		"status":  m[6],
+		"bytes":   m[7],
+		"referer": m[8],
+		"agent":   m[9],
+	}
+
+	//"GET /path HTTP/1.1"
+	request := strings.Split(m[5], " ")
+	if len(request) >= 2 {
+		fields["method"] = request[0]
+		fields["path"] = request[1]
+	}
+	if len(request) == 3 {
+		fields["protocol"] = request[2]
+	}
+
+	return fields, nil
+}
+
+type jsonParser struct {
+	ipField string
+}
+
+func (p *jsonParser) Parse(line string) (map[string]string, error) {
+	var obj map[string]interface{}
+	err := json.Unmarshal([]byte(line), &obj)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := make(map[string]string, len(obj))
+	flatten("", obj, fields)
+
+	if ip, ok := fields[p.ipField]; ok {
+		fields[IPField] = ip
+	}
+	return fields, nil
+}
+
+// flatten stores nested values under dotted keys ("request.path")
+func flatten(prefix string, obj map[string]interface{}, fields map[string]string) {
+	for k, v := range obj {
+		key := prefix + k
+		switch val := v.(type) {
+		case map[string]interface{}:
+			flatten(key+".", val, fields)
+		case string:
+			fields[key] = val
+		case float64:
+			fields[key] = strconv.FormatFloat(val, 'f', -1, 64)
+		case bool:
+			fields[key] = strconv.FormatBool(val)
+		case nil:
+			fields[key] = ""
+		default:
+			b, _ := json.Marshal(val)
+			fields[key] = string(b)
+		}
+	}
+}
+
+// <PRI>1 TIMESTAMP HOSTNAME APP-NAME PROCID MSGID STRUCTURED-DATA MSG
+var rfc5424RegEx = regexp.MustCompile(`^<(\d{1,3})>1 (\S+) (\S+) (\S+) (\S+) (\S+) (-|(?:\[(?:[^\]\\]|\\.)*\])+)(?: (.*))?$`)
+
+// [<PRI>]Mmm dd hh:mm:ss HOSTNAME TAG[PID]: MSG
+var rfc3164RegEx = regexp.MustCompile(`^(?:<(\d{1,3})>)?([A-Z][a-z]{2} [ 0-9]\d \d\d:\d\d:\d\d) (\S+) ([^:\[\s]+)(?:\[(\d+)\])?: ?(.*)$`)
+
+type syslogParser struct{}
+
+func (p *syslogParser) Parse(line string) (map[string]string, error) {
+	line = strings.TrimRight(line, "\r\n")
+
+	if m := rfc5424RegEx.FindStringSubmatch(line); m != nil {
+		fields := map[string]string{
+			"time":    nilValue(m[2]),
+			"host":    nilValue(m[3]),
+			"app":     nilValue(m[4]),
+			"pid":     nilValue(m[5]),
+			"msgid":   nilValue(m[6]),
+			"sd":      nilValue(m[7]),
+			"message": strings.TrimPrefix(m[8], "\ufeff"),
+		}
+		priority(m[1], fields)
+		return fields, nil
+	}
+
+	if m := rfc3164RegEx.FindStringSubmatch(line); m != nil {
+		fields := map[string]string{
+			"time":    m[2],
+			"host":    m[3],
+			"app":     m[4],
+			"pid":     m[5],
+			"message": m[6],
+		}
+		priority(m[1], fields)
+		return fields, nil
+	}
+
+	return nil, ErrNoMatch
+}
+
+// nilValue maps the RFC 5424 NILVALUE to an empty field
+func nilValue(s string) string {
+	if s == "-" {
+		return ""
+	}
+	return s
+}
+
+func priority(pri string, fields map[string]string) {
+	n, err := strconv.Atoi(pri)
+	if err != nil {
+		return
+	}
+	fields["priority"] = pri
+	fields["facility"] = strconv.Itoa(n / 8)
+	fields["severity"] = strconv.Itoa(n % 8)
+}
@@ -0,0 +1,82 @@
+package parser
+
+import (
+	"testing"
+)
+
+func check(t *testing.T, fields map[string]string, expected map[string]string) {
+	for k, v := range expected {
+		if fields[k] != v {
+			t.Fatalf("field %s: expected %q, got %q \n", k, v, fields[k])
+		}
+	}
+}
+
+func TestCombined(t *testing.T) {
+	p, _ := New("combined", "")
+
+	fields, err := p.Parse(`2001:db8::1 - frank [10/Oct/2000:13:55:36 -0700] "GET /apache_pb.gif HTTP/1.0" 200 2326 "http://www.example.com/start.html" "Mozilla/4.08 [en] (Win98; I ;Nav)"` + "\n")
+	if err != nil {
+		t.Fatalf("Parse failed: %s \n", err.Error())
+	}
+	check(t, fields, map[string]string{
+		"ip": "2001:db8::1", "user": "frank", "method": "GET", "path": "/apache_pb.gif",
+		"protocol": "HTTP/1.0", "status": "200", "bytes": "2326", "agent": "Mozilla/4.08 [en] (Win98; I ;Nav)",
+	})
+
+	//common format, malformed request
+	fields, err = p.Parse(`1.2.3.4 - - [10/Oct/2000:13:55:36 -0700] "\x16\x03\x01" 400 157`)
+	if err != nil {
+		t.Fatalf("Parse failed: %s \n", err.Error())
+	}
+	check(t, fields, map[string]string{"ip": "1.2.3.4", "request": `\x16\x03\x01`, "status": "400", "path": ""})
+
+	_, err = p.Parse("garbage")
+	if err != ErrNoMatch {
+		t.Fatalf("expected ErrNoMatch, got %v \n", err)
+	}
+}
+
+func TestJSON(t *testing.T) {
+	p, _ := New("json", "remote_addr")
+
+	fields, err := p.Parse(`{"remote_addr":"1.2.3.4","status":404,"request":{"method":"GET","uri":"/.env"},"tls":false}`)
+	if err != nil {
+		t.Fatalf("Parse failed: %s \n", err.Error())
+	}
+	check(t, fields, map[string]string{
+		"ip": "1.2.3.4", "status": "404", "request.method": "GET", "request.uri": "/.env", "tls": "false",
+	})
+}
+
+func TestSyslog(t *testing.T) {
+	p, _ := New("syslog", "")
+
+	fields, err := p.Parse("Oct 11 22:14:15 host sshd[4123]: Failed password for root from 10.0.0.5 port 22 ssh2\n")
+	if err != nil {
+		t.Fatalf("Parse failed: %s \n", err.Error())
+	}
+	check(t, fields, map[string]string{
+		"host": "host", "app": "sshd", "pid": "4123", "message": "Failed password for root from 10.0.0.5 port 22 ssh2",
+	})
+
+	fields, err = p.Parse(`<34>1 2003-10-11T22:14:15.003Z mymachine.example.com su - ID47 [exampleSDID@32473 iut="3"] 'su root' failed for lonvick on /dev/pts/8`)
+	if err != nil {
+		t.Fatalf("Parse failed: %s \n", err.Error())
+	}
+	check(t, fields, map[string]string{
+		"facility": "4", "severity": "2", "host": "mymachine.example.com", "app": "su", "pid": "",
+		"msgid": "ID47", "message": "'su root' failed for lonvick on /dev/pts/8",
+	})
+}
+
+func TestNew(t *testing.T) {
+	p, err := New("", "")
+	if p != nil || err != nil {
+		t.Fatalf("raw format must have no parser \n")
+	}
+	_, err = New("xml", "")
+	if err == nil {
+		t.Fatalf("expected error for unknown format \n")
+	}
+}
@@ -0,0 +1,105 @@
+package replay
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"ipvoid/config"
+	"ipvoid/voidlog"
+	"ipvoid/watch"
+	"os"
+	"time"
+)
+
+const usage = `usage: ipvoid replay [-config file] [-source logfile] [-rules file] [-v] file...
+
+Replays log files, plain or gzipped and oldest first, through the rules of a
+configured source and reports the IPs that would have been jailed.
+`
+
+// Main runs "ipvoid replay" and returns the exit code
+func Main(args []string) int {
+	flags := flag.NewFlagSet("replay", flag.ContinueOnError)
+	configFile := flags.String("config", "config.json", "configuration file")
+	sourceFile := flags.String("source", "", "LogFile of the source whose settings are used (default: the first one)")
+	rulesFile := flags.String("rules", "", "rules file to replay instead of the configured one")
+	verbose := flags.Bool("v", false, "print the log of the replay")
+	flags.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+		flags.PrintDefaults()
+	}
+	if flags.Parse(args) != nil {
+		return 2
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return 2
+	}
+
+	config.SetFile(*configFile)
+	err := config.Setup()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ipvoid replay: config error: %s\n", err.Error())
+		return 1
+	}
+
+	found, err := config.FindSource(*sourceFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ipvoid replay: %s\n", err.Error())
+		return 1
+	}
+	src := *found
+	if *rulesFile != "" {
+		src.RulesFile = *rulesFile
+		src.Rules, err = config.ReadRules(*rulesFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ipvoid replay: rules error: %s\n", err.Error())
+			return 1
+		}
+	}
+
+	err = watch.LoadDatabases()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ipvoid replay: IPDB read issue: %s\n", err.Error())
+		return 1
+	}
+	watch.SetupReputation()
+
+	if !*verbose {
+		voidlog.Output = ioutil.Discard
+	}
+
+	report, err := watch.Replay(&src, flags.Args())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ipvoid replay: %s\n", err.Error())
+		return 1
+	}
+	Print(os.Stdout, &src, report)
+	return 0
+}
+
+// Print writes a replay report
+func Print(w io.Writer, src *config.Source, report *watch.ReplayReport) {
+	fmt.Fprintf(w, "Replayed %d lines with %s", report.Lines, src.RulesFile)
+	if !report.Start.IsZero() {
+		fmt.Fprintf(w, " from %s to %s", report.Start.Format(time.RFC3339), report.End.Format(time.RFC3339))
+	}
+	fmt.Fprintln(w)
+	if report.Undated > 0 {
+		fmt.Fprintf(w, "%d lines without a timestamp\n", report.Undated)
+	}
+	if report.Skipped > 0 {
+		fmt.Fprintf(w, "%d lines before the first timestamp not replayed\n", report.Skipped)
+	}
+
+	fmt.Fprintf(w, "\nWould have jailed %d times:\n", len(report.Bans))
+	for _, b := range report.Bans {
+		fmt.Fprintf(w, "  %s  %-39s %8.2f  x%d  %s\n", b.Time.Format(time.RFC3339), b.IP, b.Points, b.Repeat, b.Reason)
+	}
+
+	fmt.Fprintln(w, "\nRule matches:")
+	for _, rule := range src.Rules {
+		fmt.Fprintf(w, "  %-30s %d\n", rule.ID, report.Matches[rule.ID])
+	}
+}
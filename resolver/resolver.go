@@ -1,6 +1,7 @@
 package resolver
 
 import (
+	"ipvoid/metrics"
 	"net"
 	"strings"
 	"sync"
@@ -12,10 +13,17 @@ var lock = sync.RWMutex{}
 
 func init() {
 	res_cache = make(map[string]string, 10000)
+	metrics.NewGaugeFunc("ipvoid_resolver_cache_entries", "Reverse DNS names cached.", func() float64 {
+		lock.RLock()
+		defer lock.RUnlock()
+		return float64(len(res_cache))
+	})
 }
 
 func Lookup(ip string) (names string) {
+	lock.RLock()
 	res, ok := res_cache[ip]
+	lock.RUnlock()
 	if ok {
 		return res
 	}
@@ -0,0 +1,183 @@
+package testrules
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"ipvoid/config"
+	"ipvoid/watch"
+	"os"
+	"strings"
+)
+
+const usage = `usage: ipvoid test-rules [-config file] [-source logfile] [-rules file] [file]
+
+Prints the IP, the matching rules and the points of every line of file, or of
+stdin. Exits with 1 when a rule doesn't compile or doesn't match any line.
+`
+
+// Main runs "ipvoid test-rules" and returns the exit code
+func Main(args []string) int {
+	flags := flag.NewFlagSet("test-rules", flag.ContinueOnError)
+	configFile := flags.String("config", "config.json", "configuration file")
+	sourceFile := flags.String("source", "", "LogFile of the source whose settings are used (default: the first one)")
+	rulesFile := flags.String("rules", "", "rules file to test instead of the configured one")
+	flags.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+		flags.PrintDefaults()
+	}
+	if flags.Parse(args) != nil || flags.NArg() > 1 {
+		return 2
+	}
+
+	config.SetFile(*configFile)
+	err := config.Setup()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ipvoid test-rules: config error: %s\n", err.Error())
+		return 1
+	}
+
+	found, err := config.FindSource(*sourceFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ipvoid test-rules: %s\n", err.Error())
+		return 1
+	}
+	src := *found
+	if *rulesFile != "" {
+		src.Rules, err = config.ReadRules(*rulesFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ipvoid test-rules: rules error: %s\n", err.Error())
+			return 1
+		}
+	}
+
+	err = watch.LoadDatabases()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ipvoid test-rules: IPDB read issue: %s\n", err.Error())
+		return 1
+	}
+	watch.SetupReputation()
+
+	var input io.Reader = os.Stdin
+	if flags.NArg() == 1 && flags.Arg(0) != "-" {
+		file, err := os.Open(flags.Arg(0))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ipvoid test-rules: %s\n", err.Error())
+			return 1
+		}
+		defer file.Close()
+		input = file
+	}
+
+	unmatched, err := Run(os.Stdout, &src, input)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ipvoid test-rules: %s\n", err.Error())
+		return 1
+	}
+	if len(unmatched) > 0 {
+		fmt.Fprintf(os.Stderr, "rules without a match: %s\n", strings.Join(unmatched, ", "))
+		return 1
+	}
+	return 0
+}
+
+// Run explains every line of input and returns the IDs of the rules that
+// matched none
+func Run(w io.Writer, src *config.Source, input io.Reader) ([]string, error) {
+	tester, err := watch.NewTester(src)
+	if err != nil {
+		return nil, err
+	}
+
+	matched := make(map[string]bool, len(src.Rules))
+	scanner := bufio.NewScanner(input)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	lineN := 0
+	for scanner.Scan() {
+		line := scanner.Text()
+		lineN++
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		e := tester.Explain(line)
+		for _, m := range e.Matches {
+			matched[m.Rule.ID] = true
+		}
+		explain(w, lineN, line, src, e)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	var unmatched []string
+	for _, rule := range src.Rules {
+		if !matched[rule.ID] {
+			unmatched = append(unmatched, rule.ID)
+		}
+	}
+	return unmatched, nil
+}
+
+func explain(w io.Writer, lineN int, line string, src *config.Source, e *watch.Explanation) {
+	fmt.Fprintf(w, "%d: %s\n", lineN, line)
+	if e.IP == "" {
+		fmt.Fprintln(w, "   ip: none")
+	} else {
+		fmt.Fprintf(w, "   ip: %s\n", e.IP)
+	}
+	if len(e.Matches) == 0 {
+		fmt.Fprintln(w, "   no rule matches")
+	}
+
+	total := 0
+	for _, m := range e.Matches {
+		rule := m.Rule
+		desc := ""
+		switch rule.Action {
+		case config.ActionWhitelist:
+			desc = "whitelist, the line is ignored"
+		case config.ActionLog:
+			desc = "log-only"
+		default:
+			//rate rules don't score on a single line
+			if rule.Count == 0 {
+				total += m.Points
+			}
+			desc = fmt.Sprintf("%s %+d", rule.Action, m.Points)
+			if m.Factors != "" {
+				desc += fmt.Sprintf(" (%d %s)", rule.Points, strings.TrimSpace(m.Factors))
+			}
+			if rule.Action == config.ActionBan && rule.BanDuration > 0 {
+				desc += fmt.Sprintf(", jails for %d minutes", rule.BanDuration)
+			}
+		}
+		if rule.Count > 0 {
+			desc += fmt.Sprintf(", on %d matches in %s", rule.Count, rule.Window)
+		}
+		if m.IP != e.IP {
+			desc += ", offender " + m.IP
+		}
+		if rule.Stop {
+			desc += ", stop"
+		}
+		if len(m.Fields) > 0 {
+			desc += " " + strings.TrimSpace(watch.FormatFields(m.Fields))
+		}
+		fmt.Fprintf(w, "   [%s] %s\n", rule.ID, desc)
+	}
+
+	conf := config.Get()
+	if e.GeoBlock != "" {
+		total += conf.GeoBlockDuration
+		fmt.Fprintf(w, "   GEO-BLOCK[%s] %+d\n", e.GeoBlock, conf.GeoBlockDuration)
+	}
+	if e.ASNBlock != 0 {
+		total += conf.ASNBlockDuration
+		fmt.Fprintf(w, "   ASN-BLOCK[AS%d] %+d\n", e.ASNBlock, conf.ASNBlockDuration)
+	}
+	if total > 0 {
+		fmt.Fprintf(w, "   total %+d, ban threshold %d\n", total, src.BanThreshold)
+	}
+}
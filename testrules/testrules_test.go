@@ -0,0 +1,47 @@
+package testrules
+
+import (
+	"bytes"
+	"ipvoid/config"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestRun(t *testing.T) {
+	src := &config.Source{
+		IpRegEx:      config.DefaultIpRegEx,
+		BanThreshold: 100,
+		Rules: []config.Rule{
+			{ID: "monitoring", Regex: regexp.MustCompile(`UptimeRobot`), Action: config.ActionWhitelist},
+			{ID: "php", Regex: regexp.MustCompile(`\.php`), Points: 20, Action: config.ActionScore},
+			{ID: "user", Regex: regexp.MustCompile(`user=(?P<user>\w+)`), Points: 5, Action: config.ActionScore},
+			{ID: "never", Regex: regexp.MustCompile(`xyz`), Points: 5, Action: config.ActionScore},
+		},
+	}
+	input := `192.0.2.1 GET /a.php?user=root
+192.0.2.2 GET / UptimeRobot /a.php
+
+no ip here
+`
+
+	var out bytes.Buffer
+	unmatched, err := Run(&out, src, strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("Run failed: %s \n", err.Error())
+	}
+	if len(unmatched) != 1 || unmatched[0] != "never" {
+		t.Fatalf("unexpected unmatched rules: %v \n", unmatched)
+	}
+
+	expected := []string{
+		"1: 192.0.2.1 GET /a.php?user=root\n   ip: 192.0.2.1\n   [php] score +20\n   [user] score +5 {user=root}\n   total +25, ban threshold 100\n",
+		"2: 192.0.2.2 GET / UptimeRobot /a.php\n   ip: 192.0.2.2\n   [monitoring] whitelist, the line is ignored\n4:",
+		"4: no ip here\n   ip: none\n   no rule matches\n",
+	}
+	for _, e := range expected {
+		if !strings.Contains(out.String(), e) {
+			t.Fatalf("expected %q in:\n%s", e, out.String())
+		}
+	}
+}
@@ -3,10 +3,17 @@ package voidlog
 import (
 	"container/ring"
 	"fmt"
+	"io"
+	"os"
+	"sync"
 	"time"
 )
 
 var LogHistory *ring.Ring
+var lock = sync.Mutex{} //serializes the writers, the watcher, the jail and the blocklists log concurrently
+
+// Output is where log lines are printed, besides LogHistory
+var Output io.Writer = os.Stdout
 
 func init() {
 	LogHistory = ring.New(10240)
@@ -14,14 +21,18 @@ func init() {
 
 func Logf(format string, a ...interface{}) {
 	s := time.Now().Format(time.Stamp) + " : " + fmt.Sprintf(format, a...)
-	fmt.Print(s)
+	lock.Lock()
+	defer lock.Unlock()
+	fmt.Fprint(Output, s)
 	LogHistory.Value = s
 	LogHistory = LogHistory.Next()
 }
 
 func Log(text string) {
 	s := time.Now().Format(time.Stamp) + " : " + text
-	fmt.Print(s)
+	lock.Lock()
+	defer lock.Unlock()
+	fmt.Fprint(Output, s)
 	LogHistory.Value = s
 	LogHistory = LogHistory.Next()
 }
@@ -0,0 +1,70 @@
+package watch
+
+import (
+	"fmt"
+	"ipvoid/config"
+	"ipvoid/ipdb"
+	"strconv"
+)
+
+// Fields added to every line when the ASN database is loaded, so rules can
+// match the AS of the offender
+const (
+	ASNField   = "asn"    //AS number, without the "AS" prefix
+	ASOrgField = "as_org" //organisation of the AS
+)
+
+// ASN returns the AS number and organisation of ip, 0 when the ASN
+// database is not loaded or doesn't have ip
+func ASN(ip string) (int, string) {
+	db, _ := asnDB.Load().(*ipdb.IPDataBase)
+	if ip == "" || db == nil || !db.Loaded {
+		return 0, ""
+	}
+	_, ipRange := db.CheckIP(ip)
+	if ipRange == nil {
+		return 0, ""
+	}
+	return ipRange.ASN, ipRange.ASOrg
+}
+
+// asnFactor is the score multiplier of the AS of ip, and its log
+func asnFactor(ip string) (int, string) {
+	asn, _ := ASN(ip)
+	m := config.Get().ASNMultipliers[asn]
+	if asn == 0 || m <= 1 {
+		return 1, ""
+	}
+	return m, fmt.Sprintf("AS%d[x%d] ", asn, m)
+}
+
+// asnBlocked reports if the AS of ip is blocked, with the AS number
+func asnBlocked(ip string) (int, bool) {
+	asn, _ := ASN(ip)
+	return asn, asn > 0 && config.Get().ASNBlocked[asn]
+}
+
+// asnFields sets the AS fields of parsed to the AS of ip, leaving the fields
+// in logged, the ones the log line has itself
+func asnFields(parsed map[string]string, logged map[string]bool, ip string) map[string]string {
+	for _, key := range []string{ASNField, ASOrgField} {
+		if !logged[key] {
+			delete(parsed, key)
+		}
+	}
+
+	asn, org := ASN(ip)
+	if asn == 0 {
+		return parsed
+	}
+	if parsed == nil {
+		parsed = make(map[string]string, 2)
+	}
+	if !logged[ASNField] {
+		parsed[ASNField] = strconv.Itoa(asn)
+	}
+	if !logged[ASOrgField] {
+		parsed[ASOrgField] = org
+	}
+	return parsed
+}
@@ -0,0 +1,130 @@
+package watch
+
+import (
+	"ipvoid/config"
+	"ipvoid/ipdb"
+	"ipvoid/notify"
+	"regexp"
+	"testing"
+)
+
+func TestASN(t *testing.T) {
+	err, db := ipdb.CreateASN("../ipdb/testasn.csv")
+	if err != nil {
+		t.Fatalf("Couldn't read ASN file %s \n", err.Error())
+	}
+	AddASNDB(db)
+	defer AddASNDB(nil)
+
+	defer config.Set(config.Get())
+	conf := *config.Get()
+	conf.ASNMultipliers = map[int]int{13335: 3}
+	conf.ASNBlocked = map[int]bool{64496: true}
+	config.Set(&conf)
+
+	tester, err := NewTester(&config.Source{
+		LogFile: "access.log",
+		IpRegEx: config.DefaultIpRegEx,
+		Rules: []config.Rule{
+			{ID: "php", Regex: regexp.MustCompile(`\.php`), Points: 10, Action: config.ActionScore},
+			{ID: "hosting", Field: ASNField, Regex: regexp.MustCompile(`^(64496|2500)$`), Points: 50, Action: config.ActionScore},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e := tester.Explain("1.0.0.1 GET /a.php")
+	if len(e.Matches) != 1 || e.Matches[0].Points != 30 || e.Matches[0].Factors != "AS13335[x3] " {
+		t.Fatalf("AS multiplier not applied: %+v \n", e.Matches)
+	}
+	if e.Parsed[ASOrgField] != "CloudFlare Inc." || e.ASNBlock != 0 {
+		t.Fatalf("unexpected explanation: %+v \n", e)
+	}
+
+	e = tester.Explain("224.0.0.1 GET /")
+	if len(e.Matches) != 1 || e.Matches[0].Rule.ID != "hosting" || e.ASNBlock != 64496 {
+		t.Fatalf("AS rule or block not applied: %+v \n", e)
+	}
+
+	e = tester.Explain("1.0.1.1 GET /a.php")
+	if len(e.Matches) != 1 || e.Matches[0].Points != 10 || e.Parsed != nil {
+		t.Fatalf("IP without AS: %+v \n", e)
+	}
+}
+
+func TestASNOffender(t *testing.T) {
+	err, db := ipdb.CreateASN("../ipdb/testasn.csv")
+	if err != nil {
+		t.Fatalf("Couldn't read ASN file %s \n", err.Error())
+	}
+	AddASNDB(db)
+	defer AddASNDB(nil)
+
+	hosting := config.Rule{ID: "hosting", Field: ASNField, Regex: regexp.MustCompile(`^64496$`), Points: 50, Action: config.ActionScore}
+	tester, err := NewTester(&config.Source{
+		LogFile: "access.log",
+		IpRegEx: config.DefaultIpRegEx,
+		Rules: []config.Rule{
+			{ID: "forwarded", Regex: regexp.MustCompile(`for=(?P<ip>[0-9.]+)`), Action: config.ActionLog},
+			hosting,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//the proxy is on CloudFlare, the client it names on the blocked AS
+	e := tester.Explain("1.0.0.1 GET / for=224.0.0.1")
+	if len(e.Matches) != 2 || e.Matches[1].IP != "224.0.0.1" || e.Parsed[ASNField] != "64496" {
+		t.Fatalf("AS of the proxy instead of the offender: %+v \n", e)
+	}
+
+	//fields of the log itself are kept
+	tester, err = NewTester(&config.Source{
+		LogFile: "app.json",
+		Format:  "json",
+		IpField: "client",
+		Rules:   []config.Rule{hosting},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e = tester.Explain(`{"client": "224.0.0.1", "asn": "private"}`)
+	if len(e.Matches) != 0 || e.Parsed[ASNField] != "private" || e.Parsed[ASOrgField] != "Example, Hosting Ltd" {
+		t.Fatalf("parsed asn field overwritten: %+v \n", e)
+	}
+}
+
+func TestASNBlockEvent(t *testing.T) {
+	err, db := ipdb.CreateASN("../ipdb/testasn.csv")
+	if err != nil {
+		t.Fatalf("Couldn't read ASN file %s \n", err.Error())
+	}
+	AddASNDB(db)
+	defer AddASNDB(nil)
+
+	defer config.Set(config.Get())
+	conf := *config.Get()
+	conf.ASNBlocked = map[int]bool{64496: true}
+	conf.ASNBlockDuration = 60
+	config.Set(&conf)
+
+	tester, err := NewTester(&config.Source{LogFile: "access.log", IpRegEx: config.DefaultIpRegEx, BanThreshold: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lock.Lock()
+	prev := Watchlist
+	Watchlist = make(map[string]float32)
+	v := processLine(scanLine(tester.src, "224.0.0.9 GET /"))
+	Watchlist = prev
+	lock.Unlock()
+	if len(v.bans) != 1 || len(v.bans[0].events) != 1 {
+		t.Fatalf("unexpected bans: %+v \n", v.bans)
+	}
+	if e := v.bans[0].events[0]; e.Type != notify.ASNBlock || e.ASN != 64496 || e.IP != "224.0.0.9" {
+		t.Fatalf("unexpected event: %+v \n", e)
+	}
+}
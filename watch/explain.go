@@ -0,0 +1,72 @@
+package watch
+
+import (
+	"ipvoid/config"
+	"ipvoid/parser"
+	"regexp"
+)
+
+// Tester explains how the lines of a source are scored, without touching
+// the watchlist or the jail
+type Tester struct {
+	src *source
+}
+
+// RuleMatch is a rule matching a line
+type RuleMatch struct {
+	Rule    *config.Rule
+	IP      string
+	Fields  map[string]string
+	Points  int    //with the proxy and reputation multipliers, 0 for log-only and whitelist rules
+	Factors string //the multipliers, as logged
+}
+
+// Explanation is how a line is scored
+type Explanation struct {
+	IP       string
+	Parsed   map[string]string
+	Matches  []RuleMatch
+	GeoBlock string //country code, when the IP is geo-blocked
+	ASNBlock int    //AS number, when the AS of the IP is blocked
+}
+
+// NewTester prepares a Tester for the rules of cfg
+func NewTester(cfg *config.Source) (*Tester, error) {
+	rIP, err := regexp.Compile(cfg.IpRegEx)
+	if err != nil {
+		return nil, err
+	}
+	p, err := parser.New(cfg.Format, cfg.IpField)
+	if err != nil {
+		return nil, err
+	}
+	return &Tester{&source{cfg, rIP, p, nil}}, nil
+}
+
+// Explain returns the rules matching line in the order processLine applies
+// them. Rate rules are listed on every match, as if their count was reached.
+func (t *Tester) Explain(line string) *Explanation {
+	s := scanLine(t.src, line)
+	e := &Explanation{IP: s.ip, Parsed: s.parsed}
+
+	for _, m := range s.matches {
+		rm := RuleMatch{Rule: m.rule, IP: m.ip, Fields: m.fields}
+		if f, ok := s.factors[m.ip]; ok && m.rule.Action != config.ActionLog {
+			rm.Points, rm.Factors = m.rule.Points*f.value, f.log
+		}
+		e.Matches = append(e.Matches, rm)
+
+		if m.rule.Action == config.ActionWhitelist {
+			return e
+		}
+		if m.rule.Stop {
+			break
+		}
+	}
+
+	e.GeoBlock, _ = geoBlocked(s.ip)
+	if asn, blocked := asnBlocked(s.ip); blocked {
+		e.ASNBlock = asn
+	}
+	return e
+}
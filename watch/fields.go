@@ -0,0 +1,101 @@
+package watch
+
+import (
+	"net"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// ipGroup is the named capture group identifying the offending IP
+const ipGroup = "ip"
+
+// extractIP finds the IP of a line with r. If r has an "ip" group its value
+// is used, otherwise the whole match. Other named groups are returned as fields.
+func extractIP(r *regexp.Regexp, line string) (string, map[string]string) {
+	match := r.FindStringSubmatch(line)
+	if match == nil {
+		return "", nil
+	}
+
+	ip, fields := matchFields(r, match)
+	if ip == "" && !hasGroup(r, ipGroup) {
+		ip = normalizeIP(match[0])
+	}
+	return ip, fields
+}
+
+func hasGroup(r *regexp.Regexp, name string) bool {
+	for _, n := range r.SubexpNames() {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// matchFields splits the named groups of a match into the IP and other fields
+func matchFields(r *regexp.Regexp, match []string) (string, map[string]string) {
+	var ip string
+	var fields map[string]string
+
+	for i, name := range r.SubexpNames() {
+		if name == "" || i >= len(match) || match[i] == "" {
+			continue
+		}
+		if name == ipGroup {
+			ip = normalizeIP(match[i])
+			continue
+		}
+		if fields == nil {
+			fields = make(map[string]string)
+		}
+		fields[name] = match[i]
+	}
+	return ip, fields
+}
+
+// normalizeIP returns the canonical form of ip, so every notation of an
+// address shares one score. Empty if ip is not valid.
+func normalizeIP(ip string) string {
+	parsedIP := net.ParseIP(strings.TrimSpace(ip))
+	if parsedIP == nil {
+		return ""
+	}
+	return parsedIP.String()
+}
+
+func mergeFields(a, b map[string]string) map[string]string {
+	if len(a) == 0 {
+		return b
+	}
+	if len(b) == 0 {
+		return a
+	}
+	res := make(map[string]string, len(a)+len(b))
+	for k, v := range a {
+		res[k] = v
+	}
+	for k, v := range b {
+		res[k] = v
+	}
+	return res
+}
+
+// FormatFields renders named group values as "{name=value ...} "
+func FormatFields(fields map[string]string) string {
+	if len(fields) == 0 {
+		return ""
+	}
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, 0, len(keys))
+	for _, k := range keys {
+		pairs = append(pairs, k+"="+fields[k])
+	}
+	return "{" + strings.Join(pairs, " ") + "} "
+}
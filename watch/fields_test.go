@@ -0,0 +1,70 @@
+package watch
+
+import (
+	"ipvoid/config"
+	"os"
+	"regexp"
+	"testing"
+)
+
+func TestExtractIP(t *testing.T) {
+	rIP := regexp.MustCompile(`^(?:(?:[0-9]{1,3}\.){3}[0-9]{1,3}\b|[0-9a-fA-F:]*:[0-9a-fA-F:.]*)`)
+	line := `10.0.0.1 - bob [10/Oct/2020:13:55:36 +0000] "GET /wp-login.php HTTP/1.1" 404 "X-Forwarded-For: 2001:DB8::7"`
+
+	ip, fields := extractIP(rIP, line)
+	if ip != "10.0.0.1" || fields != nil {
+		t.Fatalf("unexpected result: %s %v \n", ip, fields)
+	}
+
+	rule := regexp.MustCompile(`- (?P<user>\w+) .*"GET (?P<path>\S+) .*" (?P<status>\d{3}) "X-Forwarded-For: (?P<ip>[^"]+)"`)
+	match := rule.FindStringSubmatch(line)
+	ip, fields = matchFields(rule, match)
+	if ip != "2001:db8::7" {
+		t.Fatalf("expected forwarded IP, got %s \n", ip)
+	}
+	if FormatFields(fields) != "{path=/wp-login.php status=404 user=bob} " {
+		t.Fatalf("unexpected fields: %s \n", FormatFields(fields))
+	}
+
+	//ip group in IpRegEx, no match of the group means no IP
+	rIP = regexp.MustCompile(`client=(?P<ip>[0-9.]+)?`)
+	ip, _ = extractIP(rIP, "client=1.2.3.4")
+	if ip != "1.2.3.4" {
+		t.Fatalf("expected 1.2.3.4, got %s \n", ip)
+	}
+	ip, _ = extractIP(rIP, "client=unknown")
+	if ip != "" {
+		t.Fatalf("expected no IP, got %s \n", ip)
+	}
+}
+
+func TestSourceIP(t *testing.T) {
+	//the rules files of the fixture are relative to the config directory
+	wd, _ := os.Getwd()
+	os.Chdir("../config")
+	defer os.Chdir(wd)
+
+	defer config.Set(config.Get())
+	config.SetFile("testsources.json")
+	defer config.SetFile("config.json")
+	if err := config.Setup(); err != nil {
+		t.Fatalf("Couldn't read config file %s \n", err.Error())
+	}
+
+	mail, err := config.FindSource("mail.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tester, err := NewTester(mail)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e := tester.Explain("Oct 10 13:55:36 mx postfix/smtpd[4242]: warning: unknown[192.0.2.7]: SASL LOGIN authentication failed: UGFzc3dvcmQ6")
+	if e.IP != "192.0.2.7" {
+		t.Fatalf("expected 192.0.2.7, got %q \n", e.IP)
+	}
+	if len(e.Matches) != 1 || e.Matches[0].IP != "192.0.2.7" || e.Matches[0].Points != 50 {
+		t.Fatalf("unexpected matches: %+v \n", e.Matches)
+	}
+}
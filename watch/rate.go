@@ -0,0 +1,63 @@
+package watch
+
+import (
+	"ipvoid/config"
+	"time"
+)
+
+// now is the clock of the rate rule windows
+var now = time.Now
+
+type rateKey struct {
+	source string
+	rule   string
+	ip     string
+}
+
+// rateCounter holds the recent matches of a rate rule for an IP
+type rateCounter struct {
+	hits   []time.Time
+	window time.Duration
+}
+
+var rates = make(map[rateKey]*rateCounter) //guarded by lock
+
+// rateHit counts a match of a rate rule and reports if it is the Count-th
+// match of ip within Window. The counter starts over once the rule applies.
+func rateHit(src *source, rule *config.Rule, ip string) bool {
+	key := rateKey{src.cfg.LogFile, rule.ID, ip}
+	t := now()
+
+	rc, ok := rates[key]
+	if !ok {
+		rc = &rateCounter{hits: make([]time.Time, 0, rule.Count)}
+		rates[key] = rc
+	}
+	rc.window = rule.Window
+	rc.hits = append(expire(rc.hits, t.Add(-rule.Window)), t)
+
+	if len(rc.hits) >= rule.Count {
+		delete(rates, key)
+		return true
+	}
+	return false
+}
+
+// expire drops the hits before since
+func expire(hits []time.Time, since time.Time) []time.Time {
+	i := 0
+	for i < len(hits) && hits[i].Before(since) {
+		i++
+	}
+	return hits[i:]
+}
+
+// pruneRates forgets counters without a match in their window
+func pruneRates() {
+	t := now()
+	for key, rc := range rates {
+		if len(expire(rc.hits, t.Add(-rc.window))) == 0 {
+			delete(rates, key)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package watch
+
+import (
+	"ipvoid/config"
+	"testing"
+	"time"
+)
+
+func TestRateHit(t *testing.T) {
+	clock := time.Date(2020, 10, 10, 13, 0, 0, 0, time.UTC)
+	now = func() time.Time { return clock }
+	defer func() { now = time.Now }()
+
+	src := &source{cfg: &config.Source{LogFile: "test.log"}}
+	rule := &config.Rule{ID: "burst", Count: 3, Window: 10 * time.Second}
+
+	//3 matches, but not within 10 seconds
+	for _, step := range []time.Duration{0, 6 * time.Second, 6 * time.Second} {
+		clock = clock.Add(step)
+		if rateHit(src, rule, "192.0.2.1") {
+			t.Fatalf("rate rule applied outside of its window \n")
+		}
+	}
+
+	//the third match within 10 seconds applies the rule
+	clock = clock.Add(time.Second)
+	if !rateHit(src, rule, "192.0.2.1") {
+		t.Fatalf("rate rule not applied \n")
+	}
+
+	//and starts the count over
+	if rateHit(src, rule, "192.0.2.1") {
+		t.Fatalf("rate rule applied again without new matches \n")
+	}
+
+	//other IPs have their own counters
+	if rateHit(src, rule, "192.0.2.2") {
+		t.Fatalf("rate counters shared between IPs \n")
+	}
+
+	clock = clock.Add(time.Minute)
+	pruneRates()
+	if len(rates) != 0 {
+		t.Fatalf("expired counters not pruned: %v \n", rates)
+	}
+}
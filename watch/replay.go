@@ -0,0 +1,201 @@
+package watch
+
+import (
+	"bufio"
+	"compress/gzip"
+	"io"
+	"ipvoid/config"
+	"ipvoid/jail"
+	"ipvoid/parser"
+	"os"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// ReplayReport is the outcome of a replay
+type ReplayReport struct {
+	Lines   int
+	Undated int //lines without a timestamp, replayed at the time of the line before
+	Skipped int //undated lines before the first timestamp, not replayed
+	Start   time.Time
+	End     time.Time
+	Bans    []jail.WouldBan
+	Matches map[string]uint64 //rule ID: matches
+}
+
+var timeLayouts = []string{
+	"02/Jan/2006:15:04:05 -0700", //common log format
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04:05",
+	"2006/01/02 15:04:05",
+	time.Stamp, //syslog, no year
+}
+
+var timeRegex = regexp.MustCompile(`\[(\d{2}/\w{3}/\d{4}:\d{2}:\d{2}:\d{2} [+-]\d{4})\]|(\d{4}-\d{2}-\d{2}[T ]\d{2}:\d{2}:\d{2}(?:\.\d+)?(?:Z|[+-]\d{2}:?\d{2})?)|^(\w{3} [ \d]\d \d{2}:\d{2}:\d{2})`)
+
+// Replay feeds log files, plain or gzipped, through the rules of cfg. The
+// clock follows the timestamps of the lines and the jail only records the
+// bans it would make. The watchlist, the jail and their clocks are restored
+// when it returns.
+func Replay(cfg *config.Source, files []string) (*ReplayReport, error) {
+	rIP, err := regexp.Compile(cfg.IpRegEx)
+	if err != nil {
+		return nil, err
+	}
+	p, err := parser.New(cfg.Format, cfg.IpField)
+	if err != nil {
+		return nil, err
+	}
+	src := &source{cfg, rIP, p, nil}
+
+	report := &ReplayReport{Matches: make(map[string]uint64)}
+	before := make(map[string]uint64, len(cfg.Rules))
+	for _, rule := range cfg.Rules {
+		before[rule.ID] = ruleMatches.With(cfg.LogFile, rule.ID).Value()
+	}
+
+	var clock, lastTick time.Time
+	simulated := func() time.Time { return clock }
+	restoreJail := jail.Simulate(simulated, func(b jail.WouldBan) {
+		report.Bans = append(report.Bans, b)
+	})
+	defer restoreJail()
+
+	lock.Lock()
+	prevNow, prevWarm := now, warmResolver
+	prevWatchlist, prevFields, prevSubnets, prevRates := Watchlist, LastFields, Subnets, rates
+	now, warmResolver = simulated, false
+	Watchlist = make(map[string]float32, 1000)
+	LastFields = make(map[string]map[string]string, 1000)
+	Subnets = make(map[string]float32, 100)
+	rates = make(map[rateKey]*rateCounter)
+	lock.Unlock()
+	defer func() {
+		lock.Lock()
+		now, warmResolver = prevNow, prevWarm
+		Watchlist, LastFields, Subnets, rates = prevWatchlist, prevFields, prevSubnets, prevRates
+		lock.Unlock()
+	}()
+
+	for _, path := range files {
+		err := readLog(path, func(line string) {
+			report.Lines++
+			t, ok := lineTime(src, line)
+			switch {
+			case !ok && clock.IsZero():
+				//nothing to replay it at yet
+				report.Undated++
+				report.Skipped++
+				return
+			case !ok:
+				report.Undated++
+			case clock.IsZero():
+				clock, lastTick, report.Start = t, t, t
+			case t.After(clock):
+				clock = t
+			}
+
+			//run the minute ticks the simulated time went through
+			for !clock.IsZero() && !lastTick.Add(time.Minute).After(clock) {
+				lastTick = lastTick.Add(time.Minute)
+				lock.Lock()
+				decay()
+				lock.Unlock()
+				jail.Tick()
+			}
+
+			scanned := scanLine(src, line+"\n")
+			lock.Lock()
+			v := processLine(scanned)
+			lock.Unlock()
+			v.apply()
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	report.End = clock
+	for _, rule := range cfg.Rules {
+		if n := ruleMatches.With(cfg.LogFile, rule.ID).Value() - before[rule.ID]; n > 0 {
+			report.Matches[rule.ID] = n
+		}
+	}
+	return report, nil
+}
+
+// readLog calls f for every line of a plain or gzipped file
+func readLog(path string, f func(line string)) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	buffered := bufio.NewReader(file)
+	var reader io.Reader = buffered
+	if magic, err := buffered.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(buffered)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		reader = gz
+	}
+
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		f(scanner.Text())
+	}
+	return scanner.Err()
+}
+
+// lineTime finds the timestamp of a line, in its parsed time field or in
+// the line itself
+func lineTime(src *source, line string) (time.Time, bool) {
+	var candidates []string
+	if src.parser != nil {
+		if parsed, _ := src.parser.Parse(line); parsed != nil {
+			for _, key := range []string{"time", "timestamp", "@timestamp", "ts"} {
+				if v := parsed[key]; v != "" {
+					candidates = append(candidates, v)
+				}
+			}
+		}
+	}
+	if m := timeRegex.FindStringSubmatch(line); m != nil {
+		for _, v := range m[1:] {
+			if v != "" {
+				candidates = append(candidates, v)
+			}
+		}
+	}
+
+	for _, v := range candidates {
+		if t, ok := parseTime(v); ok {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+func parseTime(v string) (time.Time, bool) {
+	//unix time, e.g. in JSON logs
+	if secs, err := strconv.ParseFloat(v, 64); err == nil && secs > 1e8 {
+		return time.Unix(0, int64(secs*float64(time.Second))), true
+	}
+
+	for _, layout := range timeLayouts {
+		t, err := time.Parse(layout, v)
+		if err == nil {
+			if t.Year() == 0 {
+				t = t.AddDate(time.Now().Year(), 0, 0)
+			}
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
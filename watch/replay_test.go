@@ -0,0 +1,100 @@
+package watch
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"ipvoid/config"
+	"ipvoid/jail"
+	"os"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "replay")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	lines := `192.0.2.9 GET /leading.php without a timestamp
+192.0.2.1 - - [10/Oct/2020:13:55:36 +0000] "GET /a.php HTTP/1.1" 404 0
+192.0.2.2 - - [10/Oct/2020:13:55:40 +0000] "GET /b.php HTTP/1.1" 404 0
+192.0.2.1 - - [10/Oct/2020:13:56:10 +0000] "GET /c.php HTTP/1.1" 404 0
+192.0.2.3 - - [10/Oct/2020:13:57:00 +0000] "GET / HTTP/1.1" 200 0
+192.0.2.2 - - [10/Oct/2020:15:55:40 +0000] "GET /b.php HTTP/1.1" 404 0
+`
+	path := dir + "/access.log.1.gz"
+	file, _ := os.Create(path)
+	gz := gzip.NewWriter(file)
+	gz.Write([]byte(lines))
+	gz.Close()
+	file.Close()
+
+	defer config.Set(config.Get())
+	conf := *config.Get()
+	conf.DecreasePerMinute = 1
+	config.Set(&conf)
+	cfg := &config.Source{
+		LogFile:      "access.log",
+		Format:       "combined",
+		IpRegEx:      config.DefaultIpRegEx,
+		BanThreshold: 100,
+		Rules: []config.Rule{
+			{ID: "php", Regex: regexp.MustCompile(`\.php`), Points: 60, Action: config.ActionScore},
+		},
+	}
+
+	lock.Lock()
+	Watchlist = map[string]float32{"198.51.100.1": 10}
+	lock.Unlock()
+
+	report, err := Replay(cfg, []string{path})
+	if err != nil {
+		t.Fatalf("Replay failed: %s \n", err.Error())
+	}
+
+	//the undated leading line has no time to be replayed at
+	if report.Lines != 6 || report.Undated != 1 || report.Skipped != 1 || report.Matches["php"] != 4 {
+		t.Fatalf("unexpected report: %+v \n", report)
+	}
+	if !report.End.Equal(time.Date(2020, 10, 10, 15, 55, 40, 0, time.UTC)) {
+		t.Fatalf("unexpected end of the replay: %v \n", report.End)
+	}
+
+	//192.0.2.2 scored twice too, but two hours apart
+	if len(report.Bans) != 1 || report.Bans[0].IP != "192.0.2.1" || report.Bans[0].Reason != "rule php" {
+		t.Fatalf("unexpected bans: %+v \n", report.Bans)
+	}
+	if !report.Bans[0].Time.Equal(time.Date(2020, 10, 10, 13, 56, 10, 0, time.UTC)) {
+		t.Fatalf("ban not on the simulated clock: %v \n", report.Bans[0].Time)
+	}
+
+	//the live state and clocks are back
+	if time.Since(now()) > time.Minute || !warmResolver || jail.DryRun() || jail.Jailed("192.0.2.1") {
+		t.Fatalf("replay state not restored \n")
+	}
+	if Score("198.51.100.1") != 10 || Score("192.0.2.1") != 0 {
+		t.Fatalf("watchlist not restored: %v \n", Snapshot())
+	}
+}
+
+func TestLineTime(t *testing.T) {
+	src := &source{cfg: &config.Source{}}
+	times := map[string]string{
+		`192.0.2.1 - - [10/Oct/2020:13:55:36 +0200] "GET / HTTP/1.1" 200`: "2020-10-10T11:55:36Z",
+		`2020-10-10T13:55:36Z sshd[1]: Failed password for root`:          "2020-10-10T13:55:36Z",
+		`2020-10-10 13:55:36 client 192.0.2.1 denied`:                     "2020-10-10T13:55:36Z",
+	}
+	for line, expected := range times {
+		res, ok := lineTime(src, line)
+		if !ok || res.UTC().Format(time.RFC3339) != expected {
+			t.Fatalf("%s: expected %s, got %v \n", line, expected, res)
+		}
+	}
+
+	if _, ok := lineTime(src, "no time here"); ok {
+		t.Fatalf("time found in a line without one \n")
+	}
+}
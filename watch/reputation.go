@@ -0,0 +1,235 @@
+package watch
+
+import (
+	"context"
+	"fmt"
+	"ipvoid/config"
+	"ipvoid/metrics"
+	"ipvoid/voidlog"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ReputationProvider rates IPs from an outside source of reputation. Factor
+// multiplies the points of ip, 1 leaves them alone; tag is logged with the
+// match when the factor is greater than 1.
+type ReputationProvider interface {
+	Reputation(ip string) (factor int, tag string)
+}
+
+var reputation []ReputationProvider //guarded by lock
+
+var dnsblListed = metrics.NewCounterVec("ipvoid_dnsbl_listed_total", "Lookups of IPs listed on a DNSBL zone.", "zone")
+
+// dnsblTimeout bounds the lookups of an IP on all zones
+var dnsblTimeout = 2 * time.Second
+
+// dnsblFailureTTL is how long the result of a failed lookup is cached, so
+// an unreachable server doesn't hold up every match of an IP
+var dnsblFailureTTL = time.Minute
+
+// SetupReputation replaces the reputation providers with the configured ones
+func SetupReputation() {
+	conf := config.Get()
+	var providers []ReputationProvider
+	if len(conf.DNSBLs) > 0 {
+		providers = append(providers, NewDNSBL(conf.DNSBLs, conf.DNSBLServer, conf.DNSBLCacheTime))
+	}
+	SetReputationProviders(providers...)
+}
+
+// SetReputationProviders replaces the reputation providers
+func SetReputationProviders(providers ...ReputationProvider) {
+	lock.Lock()
+	reputation = providers
+	lock.Unlock()
+}
+
+// reputationFactor is the product of the factors of the reputation
+// providers for ip, and the log of the factors. Called without lock, the
+// lookups can take a while.
+func reputationFactor(ip string) (int, string) {
+	lock.RLock()
+	providers := reputation
+	lock.RUnlock()
+
+	factor, factorsLog := 1, ""
+	for _, p := range providers {
+		f, tag := p.Reputation(ip)
+		if f > 1 {
+			factor *= f
+			factorsLog += fmt.Sprintf("%s[x%d] ", tag, f)
+		}
+	}
+	return factor, factorsLog
+}
+
+// DNSBL looks up IPs on DNS blocklist zones. Listed IPs are multiplied by
+// the multiplier of the returned code, the highest one when a zone returns
+// several codes, and the factors of the zones are multiplied together.
+type DNSBL struct {
+	zones    []config.DNSBL
+	resolver *net.Resolver
+	ttl      time.Duration
+
+	cacheLock sync.Mutex
+	cache     map[string]dnsblResult
+	pruned    time.Time
+}
+
+type dnsblResult struct {
+	factor  int
+	tag     string
+	expires time.Time
+}
+
+// NewDNSBL makes a DNSBL provider querying server ("host:port"), or the
+// system resolver when server is empty. Results are cached for ttl.
+func NewDNSBL(zones []config.DNSBL, server string, ttl time.Duration) *DNSBL {
+	r := net.DefaultResolver
+	if server != "" {
+		r = &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, network, server)
+			},
+		}
+	}
+	return &DNSBL{
+		zones:    zones,
+		resolver: r,
+		ttl:      ttl,
+		cache:    make(map[string]dnsblResult, 1000),
+		pruned:   time.Now(),
+	}
+}
+
+// Reputation implements ReputationProvider
+func (d *DNSBL) Reputation(ip string) (int, string) {
+	name := reverseName(ip)
+	if name == "" {
+		return 1, ""
+	}
+
+	d.cacheLock.Lock()
+	res, ok := d.cache[ip]
+	d.cacheLock.Unlock()
+	if ok && time.Now().Before(res.expires) {
+		return res.factor, res.tag
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dnsblTimeout)
+	defer cancel()
+
+	factors := make([]int, len(d.zones))
+	codes := make([]string, len(d.zones))
+	failed := false
+	var wg sync.WaitGroup
+	var failLock sync.Mutex
+	for i := range d.zones {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			factor, code, err := d.lookup(ctx, &d.zones[i], name)
+			if err != nil {
+				failLock.Lock()
+				failed = true
+				failLock.Unlock()
+				voidlog.Logf("DNSBL %s: %s \n", d.zones[i].Zone, err.Error())
+			}
+			factors[i], codes[i] = factor, code
+		}(i)
+	}
+	wg.Wait()
+
+	res = dnsblResult{factor: 1, expires: time.Now().Add(d.ttl)}
+	var tags []string
+	for i, f := range factors {
+		if f > 1 {
+			res.factor *= f
+			tags = append(tags, d.zones[i].Zone+"="+codes[i])
+		}
+	}
+	if len(tags) > 0 {
+		res.tag = "DNSBL:" + strings.Join(tags, ",")
+	}
+
+	//failed lookups are tried again after a short while
+	if failed && d.ttl > dnsblFailureTTL {
+		res.expires = time.Now().Add(dnsblFailureTTL)
+	}
+	d.store(ip, res)
+	return res.factor, res.tag
+}
+
+// lookup returns the multiplier of name on zone with the code it's based on.
+// An IP that is not listed has a multiplier of 1.
+func (d *DNSBL) lookup(ctx context.Context, zone *config.DNSBL, name string) (int, string, error) {
+	addrs, err := d.resolver.LookupHost(ctx, name+zone.Zone+".")
+	if err != nil {
+		if dnsErr, ok := err.(*net.DNSError); ok && dnsErr.IsNotFound {
+			return 1, "", nil
+		}
+		return 1, "", err
+	}
+
+	sort.Strings(addrs)
+	factor, code := 1, ""
+	for _, addr := range addrs {
+		m, ok := zone.Multipliers[addr]
+		if !ok {
+			m = zone.Multiplier
+		}
+		if m > factor {
+			factor, code = m, addr
+		}
+	}
+	if factor > 1 {
+		dnsblListed.With(zone.Zone).Inc()
+	}
+	return factor, code, nil
+}
+
+// store caches res, dropping the expired entries once per ttl
+func (d *DNSBL) store(ip string, res dnsblResult) {
+	d.cacheLock.Lock()
+	defer d.cacheLock.Unlock()
+
+	t := time.Now()
+	if t.Sub(d.pruned) > d.ttl {
+		for k, v := range d.cache {
+			if t.After(v.expires) {
+				delete(d.cache, k)
+			}
+		}
+		d.pruned = t
+	}
+	d.cache[ip] = res
+}
+
+// reverseName is the DNSBL query prefix of ip: the reversed octets of an
+// IPv4 address or the reversed nibbles of an IPv6 one, ending with a dot
+func reverseName(ip string) string {
+	addr := net.ParseIP(ip)
+	if addr == nil || addr.IsLoopback() || addr.IsUnspecified() {
+		return ""
+	}
+
+	if v4 := addr.To4(); v4 != nil {
+		return fmt.Sprintf("%d.%d.%d.%d.", v4[3], v4[2], v4[1], v4[0])
+	}
+
+	const hex = "0123456789abcdef"
+	var b strings.Builder
+	for i := len(addr) - 1; i >= 0; i-- {
+		b.WriteByte(hex[addr[i]&0xf])
+		b.WriteByte('.')
+		b.WriteByte(hex[addr[i]>>4])
+		b.WriteByte('.')
+	}
+	return b.String()
+}
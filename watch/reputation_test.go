@@ -0,0 +1,163 @@
+package watch
+
+import (
+	"encoding/binary"
+	"ipvoid/config"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// dnsStandIn answers the A queries of records over UDP, like a DNSBL server.
+// Other names are answered with NXDOMAIN, names without records with
+// SERVFAIL.
+func dnsStandIn(t *testing.T, records map[string][]string, queries *int32) (string, func()) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %s \n", err.Error())
+	}
+
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			q := buf[:n]
+
+			//question name
+			var labels []string
+			i := 12
+			for i < len(q) && q[i] != 0 {
+				labels = append(labels, string(q[i+1:i+1+int(q[i])]))
+				i += 1 + int(q[i])
+			}
+			end := i + 5 //zero label, type and class
+			name := strings.ToLower(strings.Join(labels, ".")) + "."
+			qtype := binary.BigEndian.Uint16(q[i+1:])
+			if qtype == 1 {
+				atomic.AddInt32(queries, 1)
+			}
+
+			ips, listed := records[name]
+			resp := append([]byte{}, q[:2]...)
+			switch {
+			case listed && ips == nil:
+				resp = append(resp, 0x81, 0x82)
+			case listed:
+				resp = append(resp, 0x81, 0x80)
+			default:
+				resp = append(resp, 0x81, 0x83)
+			}
+			if qtype != 1 {
+				ips = nil
+			}
+			resp = append(resp, 0, 1, 0, byte(len(ips)), 0, 0, 0, 0)
+			resp = append(resp, q[12:end]...)
+			for _, ip := range ips {
+				resp = append(resp, 0xc0, 12, 0, 1, 0, 1, 0, 0, 0, 60, 0, 4)
+				resp = append(resp, net.ParseIP(ip).To4()...)
+			}
+			conn.WriteTo(resp, addr)
+		}
+	}()
+
+	return conn.LocalAddr().String(), func() { conn.Close() }
+}
+
+func TestDNSBL(t *testing.T) {
+	var queries int32
+	server, stop := dnsStandIn(t, map[string][]string{
+		"2.2.0.192.bl.test.":  {"127.0.0.2", "127.0.0.4"},
+		"2.2.0.192.pbl.test.": {"127.0.0.10"},
+		"5.2.0.192.bl.test.":  {"127.0.0.2"},
+	}, &queries)
+	defer stop()
+
+	d := NewDNSBL([]config.DNSBL{
+		{Zone: "bl.test", Multipliers: map[string]int{"127.0.0.4": 5}, Multiplier: 2},
+		{Zone: "pbl.test", Multipliers: map[string]int{"127.0.0.11": 3}},
+	}, server, time.Hour)
+
+	//highest code of bl.test, the code of pbl.test is ignored
+	factor, tag := d.Reputation("192.0.2.2")
+	if factor != 5 || tag != "DNSBL:bl.test=127.0.0.4" {
+		t.Fatalf("unexpected reputation %d %q \n", factor, tag)
+	}
+
+	factor, _ = d.Reputation("192.0.2.5")
+	if factor != 2 {
+		t.Fatalf("expected the default multiplier, got %d \n", factor)
+	}
+
+	factor, tag = d.Reputation("192.0.2.3")
+	if factor != 1 || tag != "" {
+		t.Fatalf("unlisted IP got %d %q \n", factor, tag)
+	}
+
+	//cached
+	sent := atomic.LoadInt32(&queries)
+	d.Reputation("192.0.2.2")
+	d.Reputation("192.0.2.3")
+	if atomic.LoadInt32(&queries) != sent {
+		t.Fatalf("cached lookups were queried again \n")
+	}
+}
+
+func TestDNSBLFailure(t *testing.T) {
+	var queries int32
+	server, stop := dnsStandIn(t, map[string][]string{"9.2.0.192.bl.test.": nil}, &queries)
+	defer stop()
+
+	d := NewDNSBL([]config.DNSBL{{Zone: "bl.test", Multiplier: 2}}, server, time.Hour)
+	if factor, _ := d.Reputation("192.0.2.9"); factor != 1 {
+		t.Fatalf("failed lookup got %d \n", factor)
+	}
+
+	//failures are cached for a while too
+	sent := atomic.LoadInt32(&queries)
+	d.Reputation("192.0.2.9")
+	if sent == 0 || atomic.LoadInt32(&queries) != sent {
+		t.Fatalf("failed lookup queried again, %d queries \n", atomic.LoadInt32(&queries))
+	}
+
+	d.cacheLock.Lock()
+	res := d.cache["192.0.2.9"]
+	d.cacheLock.Unlock()
+	if res.expires.After(time.Now().Add(dnsblFailureTTL)) {
+		t.Fatalf("failed lookup cached for the full TTL \n")
+	}
+}
+
+type fixedReputation int
+
+func (f fixedReputation) Reputation(ip string) (int, string) {
+	return int(f), "FIXED"
+}
+
+func TestReputationFactor(t *testing.T) {
+	SetReputationProviders(fixedReputation(2), fixedReputation(1), fixedReputation(3))
+	defer SetReputationProviders()
+
+	factor, factorsLog := scoreFactor("192.0.2.1")
+	if factor != 6 || factorsLog != "FIXED[x2] FIXED[x3] " {
+		t.Fatalf("unexpected factor %d %q \n", factor, factorsLog)
+	}
+}
+
+func TestReverseName(t *testing.T) {
+	names := map[string]string{
+		"192.0.2.1":   "1.2.0.192.",
+		"2001:db8::1": "1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.",
+		"127.0.0.1":   "",
+		"nonsense":    "",
+	}
+	for ip, name := range names {
+		if res := reverseName(ip); res != name {
+			t.Fatalf("expected %q for %s, got %q \n", name, ip, res)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package watch
+
+import (
+	"io/ioutil"
+	"ipvoid/jail"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestDryRunState(t *testing.T) {
+	dir, err := ioutil.TempDir("", "watch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	statedir = dir
+	defer func() { statedir = "state" }()
+
+	lock.Lock()
+	prev := Watchlist
+	Watchlist = map[string]float32{"192.0.2.50": 500}
+	lock.Unlock()
+	defer func() {
+		lock.Lock()
+		Watchlist = prev
+		lock.Unlock()
+	}()
+
+	restore := jail.Simulate(time.Now, func(jail.WouldBan) {})
+	StoreState()
+	restore()
+	if _, err := os.Stat(dir + "/watchlist"); !os.IsNotExist(err) {
+		t.Fatalf("dry run saved the watchlist \n")
+	}
+
+	//the next live start doesn't see the dry run scores
+	lock.Lock()
+	Watchlist = make(map[string]float32)
+	lock.Unlock()
+	loadState()
+	if Score("192.0.2.50") != 0 || jail.Jailed("192.0.2.50") {
+		t.Fatalf("dry run score restored by a live start \n")
+	}
+
+	StoreState()
+	if _, err := os.Stat(dir + "/watchlist"); err != nil {
+		t.Fatalf("live run didn't save the watchlist: %s \n", err.Error())
+	}
+}
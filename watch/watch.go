@@ -3,35 +3,95 @@ package watch
 import (
 	"encoding/gob"
 	"fmt"
+	"ipvoid/blocklist"
 	"ipvoid/config"
 	"ipvoid/filemonitor"
 	"ipvoid/ipdb"
 	"ipvoid/jail"
+	"ipvoid/metrics"
+	"ipvoid/notify"
+	"ipvoid/parser"
 	"ipvoid/resolver"
 	"ipvoid/voidlog"
 	"log"
 	"os"
 	"regexp"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
 var fm *filemonitor.FileMonitor
-var Watchlist map[string]float32 //TODO: possible RC (from web module)
+var Watchlist map[string]float32
+var LastFields map[string]map[string]string
+var Subnets map[string]float32 //aggregated scores, when subnet aggregation is on
+var lock = sync.RWMutex{}      //guards Watchlist, LastFields and Subnets
 var proxyDB *ipdb.IPDataBase
 var geoDB *ipdb.IPDataBase
+var asnDB atomic.Value //*ipdb.IPDataBase, replaced on reload
 
-const statedir string = "state"
+var statedir = "state"
+
+var warmResolver = true //look up the names of scored IPs ahead of the web page
+
+var (
+	linesProcessed = metrics.NewCounterVec("ipvoid_lines_processed_total", "Log lines checked against the rules.", "source")
+	ruleMatches    = metrics.NewCounterVec("ipvoid_rule_matches_total", "Lines matched by each rule.", "source", "rule")
+)
+
+func init() {
+	metrics.NewGaugeFunc("ipvoid_watchlist_size", "IPs with a score on the watchlist.", func() float64 {
+		lock.RLock()
+		defer lock.RUnlock()
+		return float64(len(Watchlist))
+	})
+	metrics.NewGaugeFunc("ipvoid_backlog_lines", "Lines read from watched files waiting to be processed.", func() float64 {
+		return float64(len(lines))
+	})
+}
+
+type source struct {
+	cfg    *config.Source
+	rIP    *regexp.Regexp
+	parser parser.Parser
+	fc     *filemonitor.FileChan
+}
+
+type sourceLine struct {
+	src  *source
+	line string
+}
+
+type sourceErr struct {
+	src *source
+	err string
+}
+
+var sources map[string]*source
+var lines = make(chan sourceLine, 1000)
+var errs chan sourceErr
+var reloadChan = make(chan struct{}, 1)
+var watchedFiles map[string]bool
 
 func Run() {
+	lock.Lock()
 	Watchlist = make(map[string]float32, 1000)
+	LastFields = make(map[string]map[string]string, 1000)
+	Subnets = make(map[string]float32, 100)
+	lock.Unlock()
 	loadState()
 
-	rIP, _ := regexp.Compile(config.Data.IpRegEx) //IP regexp
-
 	fm = filemonitor.NewFileMonitor()
-	fc, err := fm.AddFile(config.Data.LogFile)
-	if err != nil {
-		log.Println(err.Error())
+
+	sources = make(map[string]*source)
+	errs = make(chan sourceErr)
+	watchedFiles = make(map[string]bool)
+
+	syncSources()
+	watchConfigFiles()
+
+	if len(sources) == 0 {
+		log.Println("No log files to watch")
 		return
 	}
 
@@ -39,100 +99,526 @@ func Run() {
 
 	for {
 		select {
-		case line := <-fc.Cout:
-			//TODO: validate IP
-			ip := rIP.FindString(line)
-
-			//PROCESS HTTP REQUEST VS RULES
-			for r, v := range config.Data.Rules {
-				if r.MatchString(line) {
-					multiplyFactorsLog := ""
-					//check ProxyDB
-					if proxyDB != nil && proxyDB.Loaded {
-						_, ipRange := proxyDB.CheckIP(ip)
-
-						if ipRange != nil {
-							//multiply for proxy match
-							v = v * config.Data.ProxyScoreMultiplier
-							multiplyFactorsLog = fmt.Sprintf("PROXY[x%d] ", config.Data.ProxyScoreMultiplier)
-
-							//check if we have a country match
-							countryMatched := false
-							for _, v := range config.Data.ProxyCountriesList {
-								if v == ipRange.CoutryCode {
-									countryMatched = true
-									break
-								}
-							}
-
-							if config.Data.ProxyCountriesListModeWhitelist {
-								if !countryMatched {
-									v = v * config.Data.ProxyCountryScoreMultiplier
-									multiplyFactorsLog = multiplyFactorsLog + fmt.Sprintf("%s[x%d] ", ipRange.CoutryCode, config.Data.ProxyCountryScoreMultiplier)
-								}
-							} else {
-								if countryMatched {
-									v = v * config.Data.ProxyCountryScoreMultiplier
-									multiplyFactorsLog = multiplyFactorsLog + fmt.Sprintf("%s[x%d] ", ipRange.CoutryCode, config.Data.ProxyCountryScoreMultiplier)
-								}
-							}
-						}
-					}
-
-					Watchlist[ip] += float32(v)
-					voidlog.Log(fmt.Sprintf("%.2f | ", Watchlist[ip]) + multiplyFactorsLog + line)
-					resolver.Lookup(ip)
-
-					if Watchlist[ip] >= float32(config.Data.BanThreshold) {
-						jail.BlockIP(ip, Watchlist[ip])
-					}
+		case sl := <-lines:
+			scanned := scanLine(sl.src, sl.line)
+			lock.Lock()
+			v := processLine(scanned)
+			lock.Unlock()
+			v.apply()
 
-				}
+		case se := <-errs:
+			voidlog.Logf("%s: %s \n", se.src.cfg.LogFile, se.err)
+			if sources[se.src.cfg.LogFile] == se.src {
+				delete(sources, se.src.cfg.LogFile)
+			}
+			if len(sources) == 0 {
+				return
 			}
 
-			//PROCESS IP ITSELF
-			if geoDB != nil && geoDB.Loaded {
-				_, ipRange := geoDB.CheckIP(ip)
-				if ipRange != nil {
-					if proxyDB != nil && proxyDB.Loaded {
-
-						//check if we have a country match
-						countryMatched := false
-						for _, v := range config.Data.GeoBlockCountriesList {
-							if v == ipRange.CoutryCode {
-								countryMatched = true
-								break
-							}
-						}
-
-						if (config.Data.GeoBlockCountriesListModeWhitelist && !countryMatched) || (!config.Data.GeoBlockCountriesListModeWhitelist && countryMatched) {
-							Watchlist[ip] += float32(config.Data.GeoBlockDuration)
-							voidlog.Log(fmt.Sprintf("%.2f | ", Watchlist[ip]) + fmt.Sprintf("GEO-BLOCK[%s] ", ipRange.CoutryCode) + line)
-							jail.BlockIP(ip, Watchlist[ip])
-						}
-					}
+		case <-reloadChan:
+			reload()
+
+		case <-timer.C:
+			lock.Lock()
+			decay()
+			lock.Unlock()
+		}
+	}
+}
+
+// decay lowers the scores, once a minute. Called with lock held.
+func decay() {
+	decrease := config.Get().DecreasePerMinute
+	for k, v := range Watchlist {
+		Watchlist[k] = v - decrease
+		//log.Printf("IP Score status: %s : %.2f \n", k, Watchlist[k])
+
+		if Watchlist[k] <= 0 {
+			delete(Watchlist, k)
+			delete(LastFields, k)
+			voidlog.Logf("Removing IP: %s \n", k)
+		}
+	}
+	pruneRates()
+	for k, v := range Subnets {
+		Subnets[k] = v - decrease
+		if Subnets[k] <= 0 {
+			delete(Subnets, k)
+		}
+	}
+}
+
+// Reload asks the watcher to re-read the configuration and rules files.
+// Scores and the jail are kept.
+func Reload() {
+	select {
+	case reloadChan <- struct{}{}:
+	default:
+	}
+}
+
+func reload() {
+	err := config.Setup()
+	if err != nil {
+		voidlog.Logf("Config reload failed, keeping the current configuration: %s \n", err.Error())
+		return
+	}
+
+	conf := config.Get()
+	jail.SetWhitelist(conf.CIDRWhitelist)
+	SetupReputation()
+	err = loadASN()
+	if err != nil {
+		voidlog.Logf("ASN database not reloaded: %s \n", err.Error())
+	}
+	blocklist.Setup(conf.Blocklists)
+	err = notify.Setup(conf.Notifiers)
+	if err != nil {
+		voidlog.Logf("Notifiers not reloaded: %s \n", err.Error())
+	}
+	syncSources()
+	watchConfigFiles()
+	voidlog.Logf("Config reloaded \n")
+}
+
+// syncSources starts watching new sources, updates the rules of the running
+// ones and stops the ones no longer configured
+func syncSources() {
+	conf := config.Get()
+	configured := make(map[string]bool, len(conf.Sources))
+
+	for i := range conf.Sources {
+		cfg := &conf.Sources[i]
+		configured[cfg.LogFile] = true
+
+		rIP, err := regexp.Compile(cfg.IpRegEx) //IP regexp
+		if err != nil {
+			log.Printf("%s: bad IpRegEx: %s \n", cfg.LogFile, err.Error())
+			continue
+		}
+
+		p, err := parser.New(cfg.Format, cfg.IpField)
+		if err != nil {
+			log.Printf("%s: %s \n", cfg.LogFile, err.Error())
+			continue
+		}
+
+		if src, ok := sources[cfg.LogFile]; ok {
+			src.cfg, src.rIP, src.parser = cfg, rIP, p
+			continue
+		}
+
+		fc, err := fm.AddFile(cfg.LogFile)
+		if err != nil {
+			log.Printf("%s: %s \n", cfg.LogFile, err.Error())
+			continue
+		}
+
+		src := &source{cfg, rIP, p, fc}
+		sources[cfg.LogFile] = src
+		go forward(src, lines, errs)
+	}
+
+	for path := range sources {
+		if !configured[path] {
+			fm.RemoveFile(path)
+			delete(sources, path)
+		}
+	}
+}
+
+// watchConfigFiles reloads the configuration whenever one of its files changes
+func watchConfigFiles() {
+	if !config.Get().WatchConfigFiles {
+		return
+	}
+
+	for _, path := range config.Files() {
+		if watchedFiles[path] {
+			continue
+		}
+
+		fc, err := fm.WatchChanges(path)
+		if err != nil {
+			log.Printf("%s: can't watch for changes: %s \n", path, err.Error())
+			continue
+		}
+		watchedFiles[path] = true
+
+		go func(fc *filemonitor.FileChan) {
+			for {
+				select {
+				case <-fc.Cout:
+					Reload()
+				case <-fc.Cerr:
+					return
 				}
 			}
+		}(fc)
+	}
+}
 
-		case err := <-fc.Cerr:
-			voidlog.Log(err)
+// forward merges the output of a watched file into the shared channels
+func forward(src *source, lines chan<- sourceLine, errs chan<- sourceErr) {
+	for {
+		select {
+		case line := <-src.fc.Cout:
+			lines <- sourceLine{src, line}
+		case err := <-src.fc.Cerr:
+			errs <- sourceErr{src, err}
 			return
+		}
+	}
+}
 
-		case <-timer.C:
-			for k, v := range Watchlist {
-				Watchlist[k] = v - config.Data.DecreasePerMinute
-				//log.Printf("IP Score status: %s : %.2f \n", k, Watchlist[k])
+// verdict holds the jail and resolver calls decided by processLine. They
+// are made by apply once the lock is released, firewall commands and name
+// lookups can take a while.
+type verdict struct {
+	bans    []ban
+	lookups []string
+}
 
-				if Watchlist[k] <= 0 {
-					delete(Watchlist, k)
-					voidlog.Logf("Removing IP: %s \n", k)
-				}
+// ban jails an IP, or a subnet
+type ban struct {
+	ip     string
+	subnet bool
+	points float32
+	reason string
+	events []notify.Event //published when the IP is newly jailed
+}
+
+func (v *verdict) apply() {
+	for _, b := range v.bans {
+		if b.subnet {
+			jail.BlockSubnet(b.ip, b.points)
+			continue
+		}
+		jail.BlockIPReason(b.ip, b.points, b.reason, b.events...)
+	}
+	for _, ip := range v.lookups {
+		resolver.Lookup(ip)
+	}
+}
+
+// scan is a line with the rules matching it and the score multipliers of
+// their IPs. It's prepared by scanLine without the lock, the reputation
+// lookups of the multipliers can take a while.
+type scan struct {
+	src     *source
+	line    string
+	ip      string //of the line
+	parsed  map[string]string
+	matches []match
+	factors map[string]factor //IP: multiplier
+}
+
+// match is a rule matching a line, with the offending IP
+type match struct {
+	rule   *config.Rule
+	ip     string
+	fields map[string]string
+}
+
+// factor is the score multiplier of an IP, and its log
+type factor struct {
+	value int
+	log   string
+}
+
+// scanLine matches line against the rules of src, up to the first rule that
+// always ends the processing, and computes the multipliers of the IPs the
+// matches can score
+func scanLine(src *source, line string) *scan {
+	parsed, lineIP, lineFields := parseLine(src, line)
+
+	//the AS fields, and the rules matching them, are those of the offender:
+	//the IP of the line until a rule names another one. Fields of the log
+	//itself are kept.
+	logged := map[string]bool{}
+	for _, key := range []string{ASNField, ASOrgField} {
+		_, logged[key] = parsed[key]
+	}
+	parsed = asnFields(parsed, logged, lineIP)
+	offender := lineIP
+
+	s := &scan{src: src, line: line, ip: lineIP, factors: make(map[string]factor)}
+	for i := range src.cfg.Rules {
+		rule := &src.cfg.Rules[i]
+		ruleIP := lineIP
+		if rule.Field == ASNField || rule.Field == ASOrgField {
+			ruleIP = offender
+		}
+		ip, fields, ok := matchRule(rule, line, parsed, ruleIP, lineFields)
+		if !ok {
+			continue
+		}
+		s.matches = append(s.matches, match{rule, ip, fields})
+		if offender == lineIP && ip != lineIP {
+			offender = ip
+			parsed = asnFields(parsed, logged, ip)
+		}
+
+		if rule.Action == config.ActionWhitelist {
+			break
+		}
+		if _, ok := s.factors[ip]; !ok && rule.Action != config.ActionLog {
+			value, factorsLog := scoreFactor(ip)
+			s.factors[ip] = factor{value, factorsLog}
+		}
+		//rate rules only stop the processing once their count is reached
+		if rule.Stop && rule.Count == 0 {
+			break
+		}
+	}
+	s.parsed = parsed
+	return s
+}
+
+// processLine scores a scanned line and returns the calls it decided on.
+// Called with lock held.
+func processLine(s *scan) (v verdict) {
+	src, line := s.src, s.line
+	linesProcessed.With(src.cfg.LogFile).Inc()
+
+	//PROCESS HTTP REQUEST VS RULES
+	for _, m := range s.matches {
+		rule, ip, fields := m.rule, m.ip, m.fields
+		ruleMatches.With(src.cfg.LogFile, rule.ID).Inc()
+
+		if rule.Action == config.ActionWhitelist {
+			return
+		}
+
+		rateLog := ""
+		if rule.Count > 0 {
+			if !rateHit(src, rule, ip) {
+				continue
+			}
+			rateLog = fmt.Sprintf("RATE[%d/%s] ", rule.Count, rule.Window)
+		}
+
+		if rule.Action == config.ActionLog {
+			voidlog.Log(fmt.Sprintf("LOG-ONLY[%s] ", rule.ID) + rateLog + FormatFields(fields) + line)
+			if rule.Stop {
+				break
 			}
+			continue
+		}
+
+		f := s.factors[ip]
+		points := rule.Points * f.value
+
+		Watchlist[ip] += float32(points)
+		if len(fields) > 0 {
+			LastFields[ip] = fields
+		}
+		voidlog.Log(fmt.Sprintf("%.2f | [%s] ", Watchlist[ip], rule.ID) + rateLog + f.log + FormatFields(fields) + line)
+		if warmResolver {
+			v.lookups = append(v.lookups, ip)
+		}
+
+		if rule.Action == config.ActionBan || Watchlist[ip] >= float32(src.cfg.BanThreshold) {
+			//the rule can set the jail time, otherwise it's the score
+			jailPoints := Watchlist[ip]
+			if rule.BanDuration > 0 {
+				jailPoints = float32(rule.BanDuration)
+			}
+			v.bans = append(v.bans, ban{ip: ip, points: jailPoints, reason: "rule " + rule.ID})
+		}
+		scoreSubnet(ip, float32(points), &v)
+
+		if rule.Stop {
+			break
+		}
+	}
+
+	//PROCESS IP ITSELF
+	ip := s.ip
+	if code, blocked := geoBlocked(ip); blocked {
+		Watchlist[ip] += float32(config.Get().GeoBlockDuration)
+		voidlog.Log(fmt.Sprintf("%.2f | ", Watchlist[ip]) + fmt.Sprintf("GEO-BLOCK[%s] ", code) + line)
+		v.bans = append(v.bans, ban{ip: ip, points: Watchlist[ip], reason: "geo-block " + code,
+			events: []notify.Event{{Type: notify.GeoBlock, IP: ip, Points: Watchlist[ip], Country: code,
+				Message: fmt.Sprintf("%s geo-blocked (%s)", ip, code)}}})
+	}
+	if asn, blocked := asnBlocked(ip); blocked {
+		Watchlist[ip] += float32(config.Get().ASNBlockDuration)
+		voidlog.Log(fmt.Sprintf("%.2f | ", Watchlist[ip]) + fmt.Sprintf("ASN-BLOCK[AS%d] ", asn) + line)
+		v.bans = append(v.bans, ban{ip: ip, points: Watchlist[ip], reason: fmt.Sprintf("asn-block AS%d", asn),
+			events: []notify.Event{{Type: notify.ASNBlock, IP: ip, Points: Watchlist[ip], ASN: asn,
+				Message: fmt.Sprintf("%s blocked for its AS (AS%d)", ip, asn)}}})
+	}
+	return v
+}
+
+// parseLine parses line with the parser of src and finds its IP
+func parseLine(src *source, line string) (parsed map[string]string, lineIP string, lineFields map[string]string) {
+	if src.parser != nil {
+		parsed, _ = src.parser.Parse(line)
+	}
+
+	if parsed != nil {
+		lineIP = normalizeIP(parsed[parser.IPField])
+	}
+	if lineIP == "" {
+		lineIP, lineFields = extractIP(src.rIP, line)
+	}
+	return parsed, lineIP, lineFields
+}
+
+// matchRule applies rule to line, or to its parsed field, and returns the
+// offending IP with the named groups of the match
+func matchRule(rule *config.Rule, line string, parsed map[string]string, lineIP string, lineFields map[string]string) (string, map[string]string, bool) {
+	//field rules only apply to parsed lines
+	text := line
+	if rule.Field != "" {
+		value, ok := parsed[rule.Field]
+		if !ok {
+			return "", nil, false
 		}
+		text = value
+	}
+
+	match := rule.Regex.FindStringSubmatch(text)
+	if match == nil {
+		return "", nil, false
+	}
+
+	//a rule with an "ip" group names the offender itself
+	ip, fields := lineIP, lineFields
+	ruleIP, ruleFields := matchFields(rule.Regex, match)
+	if ruleIP != "" {
+		ip = ruleIP
 	}
+	if ip == "" {
+		return "", nil, false
+	}
+	return ip, mergeFields(fields, ruleFields), true
+}
+
+// scoreFactor is the score multiplier of ip from the proxy database, its
+// AS and the reputation providers, and the log of the multipliers
+func scoreFactor(ip string) (int, string) {
+	factor, factorsLog := proxyFactor(ip)
+	asnFactor, asnLog := asnFactor(ip)
+	repFactor, repLog := reputationFactor(ip)
+	return factor * asnFactor * repFactor, factorsLog + asnLog + repLog
 }
 
+// proxyFactor is the score multiplier of ip for being a known proxy, and
+// the log of the multipliers
+func proxyFactor(ip string) (int, string) {
+	if proxyDB == nil || !proxyDB.Loaded {
+		return 1, ""
+	}
+	_, ipRange := proxyDB.CheckIP(ip)
+	if ipRange == nil {
+		return 1, ""
+	}
+
+	//multiply for proxy match
+	conf := config.Get()
+	factor := conf.ProxyScoreMultiplier
+	multiplyFactorsLog := fmt.Sprintf("PROXY[x%d] ", conf.ProxyScoreMultiplier)
+
+	//check if we have a country match
+	countryMatched := false
+	for _, v := range conf.ProxyCountriesList {
+		if v == ipRange.CoutryCode {
+			countryMatched = true
+			break
+		}
+	}
+
+	if conf.ProxyCountriesListModeWhitelist != countryMatched {
+		factor = factor * conf.ProxyCountryScoreMultiplier
+		multiplyFactorsLog = multiplyFactorsLog + fmt.Sprintf("%s[x%d] ", ipRange.CoutryCode, conf.ProxyCountryScoreMultiplier)
+	}
+	return factor, multiplyFactorsLog
+}
+
+// geoBlocked reports if the country of ip is blocked, with the country code
+func geoBlocked(ip string) (string, bool) {
+	if ip == "" || geoDB == nil || !geoDB.Loaded {
+		return "", false
+	}
+	_, ipRange := geoDB.CheckIP(ip)
+	if ipRange == nil || proxyDB == nil || !proxyDB.Loaded {
+		return "", false
+	}
+
+	//check if we have a country match
+	conf := config.Get()
+	countryMatched := false
+	for _, v := range conf.GeoBlockCountriesList {
+		if v == ipRange.CoutryCode {
+			countryMatched = true
+			break
+		}
+	}
+
+	if conf.GeoBlockCountriesListModeWhitelist != countryMatched {
+		return ipRange.CoutryCode, true
+	}
+	return "", false
+}
+
+// scoreSubnet adds points to the aggregated score of the subnet of ip and
+// jails the subnet in v once it crosses SubnetBanThreshold
+func scoreSubnet(ip string, points float32, v *verdict) {
+	subnet := jail.Subnet(ip)
+	threshold := config.Get().SubnetBanThreshold
+	if threshold <= 0 || subnet == "" {
+		return
+	}
+
+	Subnets[subnet] += points
+	if Subnets[subnet] >= float32(threshold) {
+		v.bans = append(v.bans, ban{ip: subnet, subnet: true, points: Subnets[subnet]})
+	}
+}
+
+// Score returns the score of ip, 0 when it's not watched
+func Score(ip string) float32 {
+	lock.RLock()
+	defer lock.RUnlock()
+	return Watchlist[ip]
+}
+
+// Forget removes ip from the watchlist
+func Forget(ip string) {
+	lock.Lock()
+	defer lock.Unlock()
+	delete(Watchlist, ip)
+	delete(LastFields, ip)
+}
+
+// Snapshot returns a copy of the watchlist
+func Snapshot() map[string]float32 {
+	lock.RLock()
+	defer lock.RUnlock()
+	res := make(map[string]float32, len(Watchlist))
+	for k, v := range Watchlist {
+		res[k] = v
+	}
+	return res
+}
+
+// Fields returns the named groups of the last match of ip
+func Fields(ip string) map[string]string {
+	lock.RLock()
+	defer lock.RUnlock()
+	return LastFields[ip]
+}
+
+// StoreState saves the watchlist. A dry run leaves the saved state alone,
+// like the jail: its scores would otherwise jail IPs on the next live start.
 func StoreState() {
+	if jail.DryRun() {
+		return
+	}
+
 	if _, err := os.Stat(statedir); os.IsNotExist(err) {
 		os.MkdirAll(statedir, 0755)
 	}
@@ -142,8 +628,10 @@ func StoreState() {
 		log.Println("Couldn't save state: File create failed. " + err.Error())
 		return
 	}
+	lock.RLock()
 	encoder := gob.NewEncoder(file)
 	encoder.Encode(Watchlist)
+	lock.RUnlock()
 	file.Close()
 }
 
@@ -153,6 +641,8 @@ func loadState() {
 		return
 	}
 	defer file.Close()
+	lock.Lock()
+	defer lock.Unlock()
 	decoder := gob.NewDecoder(file)
 	err = decoder.Decode(&Watchlist)
 	if err != nil {
@@ -162,12 +652,70 @@ func loadState() {
 	}
 
 	for ip, v := range Watchlist {
-		if v >= float32(config.Data.BanThreshold) {
+		//restored jail entries already carry their remaining time
+		if v >= float32(config.Get().BanThreshold) && !jail.Jailed(ip) {
 			jail.BlockIP(ip, v)
 		}
 	}
 }
 
+// Location returns the country of ip and if it's a known proxy, based on
+// the loaded proxy and geo databases
+func Location(ip string) (code string, name string, proxy bool) {
+	if proxyDB != nil && proxyDB.Loaded {
+		_, ipRange := proxyDB.CheckIP(ip)
+		if ipRange != nil {
+			return ipRange.CoutryCode, ipRange.CoutryName, true
+		}
+	}
+	if geoDB != nil && geoDB.Loaded {
+		_, ipRange := geoDB.CheckIP(ip)
+		if ipRange != nil {
+			return ipRange.CoutryCode, ipRange.CoutryName, false
+		}
+	}
+	return "", "", false
+}
+
+// LoadDatabases loads the proxy, geo and ASN databases enabled in the
+// configuration
+func LoadDatabases() error {
+	conf := config.Get()
+	if conf.UseProxyDetection {
+		err, ipProxy := ipdb.Create(conf.ProxyCSV)
+		if err != nil {
+			return err
+		}
+		AddProxyDB(ipProxy)
+	}
+
+	if conf.UseGEODetection {
+		err, ipGeo := ipdb.Create(conf.GeoBlockCSV)
+		if err != nil {
+			return err
+		}
+		AddGeoDB(ipGeo)
+	}
+
+	return loadASN()
+}
+
+// loadASN loads the ASN database, or drops it when ASN detection is off. The
+// current database is kept when the file can't be read.
+func loadASN() error {
+	conf := config.Get()
+	if !conf.UseASNDetection {
+		AddASNDB(nil)
+		return nil
+	}
+	err, ipASN := ipdb.CreateASN(conf.ASNCSV)
+	if err != nil {
+		return err
+	}
+	AddASNDB(ipASN)
+	return nil
+}
+
 func AddProxyDB(prDB *ipdb.IPDataBase) {
 	proxyDB = prDB
 }
@@ -175,3 +723,7 @@ func AddProxyDB(prDB *ipdb.IPDataBase) {
 func AddGeoDB(gDB *ipdb.IPDataBase) {
 	geoDB = gDB
 }
+
+func AddASNDB(aDB *ipdb.IPDataBase) {
+	asnDB.Store(aDB)
+}
@@ -0,0 +1,146 @@
+package web
+
+import (
+	"encoding/json"
+	"errors"
+	"ipvoid/jail"
+	"ipvoid/voidlog"
+	"ipvoid/watch"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// actionRequest is the body of write operations, sent as a form or JSON
+type actionRequest struct {
+	IP      string  `json:"ip"`
+	Minutes float32 `json:"minutes"`
+	CIDR    string  `json:"cidr"`
+}
+
+type actionResult struct {
+	Result string `json:"result"`
+}
+
+func registerActions(mux *http.ServeMux) {
+	mux.HandleFunc(apiPrefix+"ban", requireAuth(actionBan))
+	mux.HandleFunc(apiPrefix+"unban", requireAuth(actionUnban))
+	mux.HandleFunc(apiPrefix+"forget", requireAuth(actionForget))
+	mux.HandleFunc(apiPrefix+"whitelist/add", requireAuth(actionWhitelistAdd))
+	mux.HandleFunc(apiPrefix+"whitelist/remove", requireAuth(actionWhitelistRemove))
+	mux.HandleFunc("/login", loginPage)
+}
+
+func parseAction(r *http.Request) (*actionRequest, error) {
+	a := &actionRequest{}
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		err := json.NewDecoder(r.Body).Decode(a)
+		if err != nil {
+			return nil, errors.New("bad JSON body")
+		}
+		return a, nil
+	}
+
+	a.IP = r.PostFormValue("ip")
+	a.CIDR = r.PostFormValue("cidr")
+	if s := r.PostFormValue("minutes"); s != "" {
+		m, err := strconv.ParseFloat(s, 32)
+		if err != nil {
+			return nil, errors.New("minutes must be a number")
+		}
+		a.Minutes = float32(m)
+	}
+	return a, nil
+}
+
+// respond answers HTML forms with a redirect to the stats page, API clients with JSON
+func respond(w http.ResponseWriter, r *http.Request, err error, result string) {
+	if r.PostFormValue("return") == "stats" {
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		http.Redirect(w, r, "/stats", http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, actionResult{result})
+}
+
+func actionBan(w http.ResponseWriter, r *http.Request, user string) {
+	a, err := parseAction(r)
+	if err == nil && a.Minutes <= 0 {
+		err = errors.New("minutes must be a positive number")
+	}
+	if err == nil {
+		err = jail.BlockIPFor(a.IP, a.Minutes)
+	}
+	if err == nil {
+		voidlog.Logf("WEB: %s jailed %s for %.0f minutes \n", user, a.IP, a.Minutes)
+	}
+	respond(w, r, err, "jailed")
+}
+
+func actionUnban(w http.ResponseWriter, r *http.Request, user string) {
+	a, err := parseAction(r)
+	if err == nil {
+		err = jail.UnblockIP(a.IP)
+	}
+	if err == nil {
+		//the score goes with the jail, not when the unban failed
+		watch.Forget(normalizeIP(a.IP))
+		voidlog.Logf("WEB: %s released %s \n", user, a.IP)
+	}
+	respond(w, r, err, "released")
+}
+
+func actionForget(w http.ResponseWriter, r *http.Request, user string) {
+	a, err := parseAction(r)
+	if err == nil {
+		ip := normalizeIP(a.IP)
+		if ip == "" {
+			err = errors.New("Parameter is not an IP")
+		} else {
+			watch.Forget(ip)
+			voidlog.Logf("WEB: %s removed %s from the watchlist \n", user, ip)
+		}
+	}
+	respond(w, r, err, "removed")
+}
+
+func actionWhitelistAdd(w http.ResponseWriter, r *http.Request, user string) {
+	a, err := parseAction(r)
+	if err == nil {
+		if _, _, err = net.ParseCIDR(a.CIDR); err != nil {
+			err = errors.New("parameter is not in a CIDR notation")
+		}
+	}
+	if err == nil {
+		jail.AppendWhitelist(a.CIDR)
+		voidlog.Logf("WEB: %s whitelisted %s \n", user, a.CIDR)
+	}
+	respond(w, r, err, "whitelisted")
+}
+
+func actionWhitelistRemove(w http.ResponseWriter, r *http.Request, user string) {
+	a, err := parseAction(r)
+	if err == nil {
+		err = jail.RemoveWhitelist(a.CIDR)
+	}
+	if err == nil {
+		voidlog.Logf("WEB: %s removed %s from the whitelist \n", user, a.CIDR)
+	}
+	respond(w, r, err, "removed")
+}
+
+func normalizeIP(ip string) string {
+	res := net.ParseIP(ip)
+	if res == nil {
+		return ""
+	}
+	return res.String()
+}
@@ -0,0 +1,349 @@
+package web
+
+import (
+	"encoding/json"
+	"errors"
+	"ipvoid/blocklist"
+	"ipvoid/jail"
+	"ipvoid/resolver"
+	"ipvoid/watch"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const apiPrefix = "/api/v1/"
+const defaultLimit = 100
+const maxLimit = 1000
+
+type apiList struct {
+	Total  int         `json:"total"`
+	Offset int         `json:"offset"`
+	Limit  int         `json:"limit"`
+	Items  interface{} `json:"items"`
+}
+
+type apiError struct {
+	Error string `json:"error"`
+}
+
+type apiWatched struct {
+	IP      string            `json:"ip"`
+	Score   float32           `json:"score"`
+	Host    string            `json:"host"`
+	Fields  map[string]string `json:"fields,omitempty"`
+	Country string            `json:"country,omitempty"`
+	Proxy   bool              `json:"proxy"`
+	ASN     int               `json:"asn,omitempty"`
+	ASOrg   string            `json:"as_org,omitempty"`
+	Lists   []string          `json:"lists,omitempty"` //blocklists of the IP
+}
+
+type apiJailed struct {
+	IP        string     `json:"ip"`
+	Points    float32    `json:"points"`
+	Expires   *time.Time `json:"expires,omitempty"` //not set for permanent bans
+	Permanent bool       `json:"permanent"`
+	Repeat    int        `json:"repeat"`
+	Host      string     `json:"host"`
+	Country   string     `json:"country,omitempty"`
+	Proxy     bool       `json:"proxy"`
+	ASN       int        `json:"asn,omitempty"`
+	ASOrg     string     `json:"as_org,omitempty"`
+	Lists     []string   `json:"lists,omitempty"`
+}
+
+type apiHistory struct {
+	Time string `json:"time"`
+	IP   string `json:"ip"`
+}
+
+// apiWouldBan is a ban recorded in dry run mode
+type apiWouldBan struct {
+	Time   time.Time `json:"time"`
+	IP     string    `json:"ip"`
+	Points float32   `json:"points"`
+	Repeat int       `json:"repeat"`
+	Reason string    `json:"reason"`
+}
+
+type apiIP struct {
+	IP          string            `json:"ip"`
+	Host        string            `json:"host"`
+	Score       float32           `json:"score"`
+	Fields      map[string]string `json:"fields,omitempty"`
+	Jailed      bool              `json:"jailed"`
+	Points      float32           `json:"points"`
+	Expires     *time.Time        `json:"expires,omitempty"`
+	Permanent   bool              `json:"permanent"`
+	Repeat      int               `json:"repeat"`
+	Whitelisted bool              `json:"whitelisted"`
+	Country     string            `json:"country,omitempty"`
+	CountryName string            `json:"country_name,omitempty"`
+	Proxy       bool              `json:"proxy"`
+	ASN         int               `json:"asn,omitempty"`
+	ASOrg       string            `json:"as_org,omitempty"`
+	Lists       []string          `json:"lists,omitempty"`
+}
+
+// apiBlocklist is an imported blocklist
+type apiBlocklist struct {
+	Name    string     `json:"name"`
+	URL     string     `json:"url"`
+	Entries int        `json:"entries"`
+	Invalid int        `json:"invalid"`
+	Updated *time.Time `json:"updated,omitempty"` //not set before the first load
+	Error   string     `json:"error,omitempty"`
+}
+
+// listQuery holds the pagination and filter parameters of list endpoints:
+// offset, limit, min_score, max_score and cidr
+type listQuery struct {
+	offset   int
+	limit    int
+	minScore float64
+	maxScore float64
+	cidr     *net.IPNet
+}
+
+func registerAPI(mux *http.ServeMux) {
+	mux.HandleFunc(apiPrefix+"watchlist", apiGet(apiWatchlist))
+	mux.HandleFunc(apiPrefix+"jail", apiGet(apiJail))
+	mux.HandleFunc(apiPrefix+"history", apiGet(apiJailHistory))
+	mux.HandleFunc(apiPrefix+"ip/", apiGet(apiIPInfo))
+	mux.HandleFunc(apiPrefix+"dryrun", apiGet(apiDryRun))
+	mux.HandleFunc(apiPrefix+"blocklists", apiGet(apiBlocklists))
+}
+
+func apiGet(h func(w http.ResponseWriter, r *http.Request)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		h(w, r)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, apiError{msg})
+}
+
+func parseListQuery(r *http.Request) (*listQuery, error) {
+	q := &listQuery{limit: defaultLimit, maxScore: -1}
+	v := r.URL.Query()
+	var err error
+
+	if s := v.Get("offset"); s != "" {
+		q.offset, err = strconv.Atoi(s)
+		if err != nil || q.offset < 0 {
+			return nil, errors.New("offset must be a non-negative integer")
+		}
+	}
+	if s := v.Get("limit"); s != "" {
+		q.limit, err = strconv.Atoi(s)
+		if err != nil || q.limit < 1 || q.limit > maxLimit {
+			return nil, errors.New("limit must be between 1 and " + strconv.Itoa(maxLimit))
+		}
+	}
+	if s := v.Get("min_score"); s != "" {
+		q.minScore, err = strconv.ParseFloat(s, 32)
+		if err != nil {
+			return nil, errors.New("min_score must be a number")
+		}
+	}
+	if s := v.Get("max_score"); s != "" {
+		q.maxScore, err = strconv.ParseFloat(s, 32)
+		if err != nil {
+			return nil, errors.New("max_score must be a number")
+		}
+	}
+	if s := v.Get("cidr"); s != "" {
+		_, q.cidr, err = net.ParseCIDR(s)
+		if err != nil {
+			return nil, errors.New("cidr is not in a CIDR notation")
+		}
+	}
+	return q, nil
+}
+
+func (q *listQuery) match(ip string, score float32) bool {
+	if float64(score) < q.minScore || (q.maxScore >= 0 && float64(score) > q.maxScore) {
+		return false
+	}
+	if q.cidr != nil && !q.cidr.Contains(net.ParseIP(ip)) {
+		return false
+	}
+	return true
+}
+
+// page returns the bounds of the requested page in a list of n items
+func (q *listQuery) page(n int) (int, int) {
+	start := q.offset
+	if start > n {
+		start = n
+	}
+	end := start + q.limit
+	if end > n {
+		end = n
+	}
+	return start, end
+}
+
+func apiWatchlist(w http.ResponseWriter, r *http.Request) {
+	q, err := parseListQuery(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	items := []apiWatched{}
+	for ip, score := range watch.Snapshot() {
+		if q.match(ip, score) {
+			items = append(items, apiWatched{IP: ip, Score: score})
+		}
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].Score > items[j].Score
+	})
+
+	start, end := q.page(len(items))
+	page := items[start:end]
+	for i := range page {
+		page[i].Host = strings.TrimSpace(resolver.Lookup(page[i].IP))
+		page[i].Fields = watch.Fields(page[i].IP)
+		page[i].Country, _, page[i].Proxy = watch.Location(page[i].IP)
+		page[i].ASN, page[i].ASOrg = watch.ASN(page[i].IP)
+		page[i].Lists = jail.Listed(page[i].IP)
+	}
+
+	writeJSON(w, http.StatusOK, apiList{len(items), q.offset, q.limit, page})
+}
+
+func apiJail(w http.ResponseWriter, r *http.Request) {
+	q, err := parseListQuery(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	items := []apiJailed{}
+	for ip, points := range jail.Jail() {
+		if q.match(ip, points) {
+			items = append(items, apiJailed{IP: ip, Points: points})
+		}
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].Points > items[j].Points
+	})
+
+	start, end := q.page(len(items))
+	page := items[start:end]
+	for i := range page {
+		page[i].Permanent = jail.Permanent(page[i].IP)
+		if !page[i].Permanent {
+			expires := jail.Expiry(page[i].Points)
+			page[i].Expires = &expires
+		}
+		_, _, page[i].Repeat = jail.IPStatus(page[i].IP)
+		page[i].Host = strings.TrimSpace(resolver.Lookup(page[i].IP))
+		page[i].Country, _, page[i].Proxy = watch.Location(page[i].IP)
+		page[i].ASN, page[i].ASOrg = watch.ASN(page[i].IP)
+		page[i].Lists = jail.Listed(page[i].IP)
+	}
+
+	writeJSON(w, http.StatusOK, apiList{len(items), q.offset, q.limit, page})
+}
+
+func apiJailHistory(w http.ResponseWriter, r *http.Request) {
+	q, err := parseListQuery(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	items := []apiHistory{}
+	for _, h := range jail.History() {
+		//"<time> : <ip>"
+		i := strings.LastIndex(h, " : ")
+		if i == -1 {
+			continue
+		}
+		entry := apiHistory{h[:i], h[i+3:]}
+		if q.cidr != nil && !q.cidr.Contains(net.ParseIP(entry.IP)) {
+			continue
+		}
+		items = append(items, entry)
+	}
+
+	start, end := q.page(len(items))
+	writeJSON(w, http.StatusOK, apiList{len(items), q.offset, q.limit, items[start:end]})
+}
+
+// apiDryRun lists the bans the jail would have made, newest first
+func apiDryRun(w http.ResponseWriter, r *http.Request) {
+	q, err := parseListQuery(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	items := []apiWouldBan{}
+	for _, b := range jail.WouldBans() {
+		if q.match(b.IP, b.Points) {
+			items = append(items, apiWouldBan(b))
+		}
+	}
+
+	start, end := q.page(len(items))
+	writeJSON(w, http.StatusOK, apiList{len(items), q.offset, q.limit, items[start:end]})
+}
+
+// apiBlocklists lists the imported blocklists, sorted by name
+func apiBlocklists(w http.ResponseWriter, r *http.Request) {
+	items := []apiBlocklist{}
+	for _, l := range blocklist.Lists() {
+		item := apiBlocklist{Name: l.Name, URL: l.URL, Entries: l.Entries, Invalid: l.Invalid, Error: l.Err}
+		if !l.Updated.IsZero() {
+			updated := l.Updated
+			item.Updated = &updated
+		}
+		items = append(items, item)
+	}
+	writeJSON(w, http.StatusOK, items)
+}
+
+func apiIPInfo(w http.ResponseWriter, r *http.Request) {
+	res := net.ParseIP(strings.TrimPrefix(r.URL.Path, apiPrefix+"ip/"))
+	if res == nil {
+		writeError(w, http.StatusBadRequest, "Parameter is not an IP")
+		return
+	}
+	ip := res.String()
+
+	info := apiIP{IP: ip}
+	info.Host = strings.TrimSpace(resolver.Lookup(ip))
+	info.Score = watch.Score(ip)
+	info.Fields = watch.Fields(ip)
+	info.Points, info.Jailed, info.Repeat = jail.IPStatus(ip)
+	info.Permanent = jail.Permanent(ip)
+	if info.Jailed && !info.Permanent {
+		expires := jail.Expiry(info.Points)
+		info.Expires = &expires
+	}
+	info.Whitelisted = jail.Whitelisted(ip)
+	info.Country, info.CountryName, info.Proxy = watch.Location(ip)
+	info.ASN, info.ASOrg = watch.ASN(ip)
+	info.Lists = jail.Listed(ip)
+
+	writeJSON(w, http.StatusOK, info)
+}
@@ -0,0 +1,76 @@
+package web
+
+import (
+	"encoding/json"
+	"ipvoid/jail"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type mockFireWall struct{}
+
+func (mf *mockFireWall) Init() error                                { return nil }
+func (mf *mockFireWall) Clear() error                               { return nil }
+func (mf *mockFireWall) Ban(ip string, timeout time.Duration) error { return nil }
+func (mf *mockFireWall) Unban(ip string) error                      { return nil }
+
+func get(t *testing.T, mux *http.ServeMux, url string, status int, v interface{}) {
+	req := httptest.NewRequest(http.MethodGet, url, nil)
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	if rec.Code != status {
+		t.Fatalf("GET %s: expected %d, got %d: %s \n", url, status, rec.Code, rec.Body.String())
+	}
+	if v != nil {
+		err := json.Unmarshal(rec.Body.Bytes(), v)
+		if err != nil {
+			t.Fatalf("GET %s: bad JSON: %s \n", url, err.Error())
+		}
+	}
+}
+
+func TestAPI(t *testing.T) {
+	jail.Setup(&mockFireWall{})
+	jail.BlockIPFor("192.0.2.1", 10)
+	jail.BlockIPFor("192.0.2.2", 20)
+	jail.BlockIPFor("198.51.100.1", 30)
+
+	mux := http.NewServeMux()
+	registerAPI(mux)
+
+	var list struct {
+		Total int         `json:"total"`
+		Items []apiJailed `json:"items"`
+	}
+	get(t, mux, "/api/v1/jail?cidr=192.0.2.0/24&limit=1", http.StatusOK, &list)
+	if list.Total != 2 || len(list.Items) != 1 || list.Items[0].IP != "192.0.2.2" {
+		t.Fatalf("unexpected jail page: %+v \n", list)
+	}
+
+	get(t, mux, "/api/v1/jail?min_score=15&offset=1", http.StatusOK, &list)
+	if list.Total != 2 || len(list.Items) != 1 || list.Items[0].IP != "192.0.2.2" || list.Items[0].Repeat != 0 {
+		t.Fatalf("unexpected jail page: %+v \n", list)
+	}
+
+	var history struct {
+		Total int          `json:"total"`
+		Items []apiHistory `json:"items"`
+	}
+	get(t, mux, "/api/v1/history?cidr=198.51.100.0/24", http.StatusOK, &history)
+	if history.Total != 1 || history.Items[0].IP != "198.51.100.1" {
+		t.Fatalf("unexpected history: %+v \n", history)
+	}
+
+	var info apiIP
+	get(t, mux, "/api/v1/ip/198.51.100.1", http.StatusOK, &info)
+	if !info.Jailed || info.Points != 30 || info.Expires == nil {
+		t.Fatalf("unexpected ip info: %+v \n", info)
+	}
+
+	get(t, mux, "/api/v1/watchlist", http.StatusOK, nil)
+	get(t, mux, "/api/v1/ip/nope", http.StatusBadRequest, nil)
+	get(t, mux, "/api/v1/jail?limit=0", http.StatusBadRequest, nil)
+	get(t, mux, "/api/v1/jail?cidr=1.2.3.4", http.StatusBadRequest, nil)
+}
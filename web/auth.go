@@ -0,0 +1,157 @@
+package web
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"ipvoid/config"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const csrfField = "csrf_token"
+const csrfHeader = "X-CSRF-Token"
+const sessionCookie = "ipvoid_session"
+const authRealm = "ipvoid"
+
+// csrfSecret signs the CSRF tokens of basic auth users. A new one is made on
+// every start, so pages rendered before a restart have to be reloaded.
+var csrfSecret = make([]byte, 32)
+
+func init() {
+	rand.Read(csrfSecret)
+}
+
+// authEnabled reports if write operations are configured
+func authEnabled() bool {
+	conf := config.Get()
+	return len(conf.WebAuthTokens) > 0 || len(conf.WebAuthUsers) > 0
+}
+
+// authenticate returns the user of an authenticated request. Bearer token
+// requests get user "token", they can't come from a browser form and
+// need no CSRF protection.
+func authenticate(r *http.Request) (user string, basic bool, ok bool) {
+	h := r.Header.Get("Authorization")
+	if strings.HasPrefix(h, "Bearer ") {
+		token := []byte(strings.TrimPrefix(h, "Bearer "))
+		for _, t := range config.Get().WebAuthTokens {
+			if t != "" && subtle.ConstantTimeCompare(token, []byte(t)) == 1 {
+				return "token", false, true
+			}
+		}
+		return "", false, false
+	}
+
+	name, pass, hasBasic := r.BasicAuth()
+	if !hasBasic {
+		return "", false, false
+	}
+	hash, exists := config.Get().WebAuthUsers[name]
+	if !exists || bcrypt.CompareHashAndPassword([]byte(hash), []byte(pass)) != nil {
+		return "", true, false
+	}
+	return name, true, true
+}
+
+// session returns the browser session of r, starting a new one on w when
+// there is none. Basic auth has no session of its own; this one ties the
+// CSRF tokens to a browser.
+func session(w http.ResponseWriter, r *http.Request) string {
+	if c, err := r.Cookie(sessionCookie); err == nil && c.Value != "" {
+		return c.Value
+	}
+	id := make([]byte, 16)
+	rand.Read(id)
+	c := &http.Cookie{
+		Name:     sessionCookie,
+		Value:    hex.EncodeToString(id),
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteStrictMode,
+	}
+	http.SetCookie(w, c)
+	return c.Value
+}
+
+// csrfToken is the CSRF token of user in a browser session
+func csrfToken(user string, session string) string {
+	mac := hmac.New(sha256.New, csrfSecret)
+	mac.Write([]byte(user + "\x00" + session))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// checkCSRF validates the token and origin of a browser request
+func checkCSRF(r *http.Request, user string) bool {
+	c, err := r.Cookie(sessionCookie)
+	if err != nil || c.Value == "" {
+		return false
+	}
+	token := r.Header.Get(csrfHeader)
+	if token == "" {
+		token = r.PostFormValue(csrfField)
+	}
+	if !hmac.Equal([]byte(token), []byte(csrfToken(user, c.Value))) {
+		return false
+	}
+
+	//reject cross site requests when the browser tells where they come from
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		origin = r.Header.Get("Referer")
+	}
+	if origin != "" {
+		u, err := url.Parse(origin)
+		if err != nil || u.Host != r.Host {
+			return false
+		}
+	}
+	return true
+}
+
+// requireAuth guards write operations
+func requireAuth(h func(w http.ResponseWriter, r *http.Request, user string)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		if !authEnabled() {
+			writeError(w, http.StatusForbidden, "write operations are disabled, no WebAuthTokens or WebAuthUsers configured")
+			return
+		}
+
+		user, basic, ok := authenticate(r)
+		if !ok {
+			w.Header().Set("WWW-Authenticate", `Basic realm="`+authRealm+`"`)
+			writeError(w, http.StatusUnauthorized, "unauthorized")
+			return
+		}
+		if basic && !checkCSRF(r, user) {
+			writeError(w, http.StatusForbidden, "bad CSRF token")
+			return
+		}
+		h(w, r, user)
+	}
+}
+
+// loginPage asks the browser for basic auth credentials and returns to the
+// stats page, which then shows the write controls
+func loginPage(w http.ResponseWriter, r *http.Request) {
+	if !authEnabled() {
+		http.Error(w, "write operations are disabled", http.StatusForbidden)
+		return
+	}
+	if _, basic, ok := authenticate(r); !ok || !basic {
+		w.Header().Set("WWW-Authenticate", `Basic realm="`+authRealm+`"`)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	http.Redirect(w, r, "/stats", http.StatusSeeOther)
+}
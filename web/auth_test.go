@@ -0,0 +1,143 @@
+package web
+
+import (
+	"html/template"
+	"io/ioutil"
+	"ipvoid/blocklist"
+	"ipvoid/config"
+	"ipvoid/jail"
+	"ipvoid/watch"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func post(mux *http.ServeMux, path string, form url.Values, setup func(r *http.Request)) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if setup != nil {
+		setup(req)
+	}
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestAuth(t *testing.T) {
+	jail.Setup(&mockFireWall{})
+	mux := http.NewServeMux()
+	registerActions(mux)
+
+	form := url.Values{"ip": {"203.0.113.5"}, "minutes": {"15"}}
+
+	defer config.Set(config.Get())
+	config.Set(&config.Configuration{})
+	if rec := post(mux, "/api/v1/ban", form, nil); rec.Code != http.StatusForbidden {
+		t.Fatalf("expected writes disabled, got %d \n", rec.Code)
+	}
+
+	hash, _ := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	config.Set(&config.Configuration{
+		WebAuthTokens: []string{"tok3n"},
+		WebAuthUsers:  map[string]string{"admin": string(hash)},
+	})
+
+	if rec := post(mux, "/api/v1/ban", form, nil); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 without credentials, got %d \n", rec.Code)
+	}
+	rec := post(mux, "/api/v1/ban", form, func(r *http.Request) { r.Header.Set("Authorization", "Bearer wrong") })
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 for a bad token, got %d \n", rec.Code)
+	}
+	rec = post(mux, "/api/v1/ban", form, func(r *http.Request) { r.Header.Set("Authorization", "Bearer tok3n") })
+	if rec.Code != http.StatusOK || !jail.Jailed("203.0.113.5") {
+		t.Fatalf("bearer ban failed: %d %s \n", rec.Code, rec.Body.String())
+	}
+
+	//basic auth needs a CSRF token
+	rec = post(mux, "/api/v1/unban", form, func(r *http.Request) { r.SetBasicAuth("admin", "wrong") })
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 for a bad password, got %d \n", rec.Code)
+	}
+	rec = post(mux, "/api/v1/unban", form, func(r *http.Request) { r.SetBasicAuth("admin", "secret") })
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 without CSRF token, got %d \n", rec.Code)
+	}
+
+	//the token belongs to the session of the page it was rendered in
+	inSession := func(id string) func(r *http.Request) {
+		return func(r *http.Request) {
+			r.SetBasicAuth("admin", "secret")
+			r.AddCookie(&http.Cookie{Name: sessionCookie, Value: id})
+		}
+	}
+	form.Set("csrf_token", csrfToken("admin", "s1"))
+	form.Set("return", "stats")
+	rec = post(mux, "/api/v1/unban", form, func(r *http.Request) { r.SetBasicAuth("admin", "secret") })
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 without a session, got %d \n", rec.Code)
+	}
+	rec = post(mux, "/api/v1/unban", form, inSession("s2"))
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 for the token of another session, got %d \n", rec.Code)
+	}
+	rec = post(mux, "/api/v1/unban", form, func(r *http.Request) {
+		inSession("s1")(r)
+		r.Header.Set("Origin", "http://evil.example")
+	})
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 for a cross site request, got %d \n", rec.Code)
+	}
+	rec = post(mux, "/api/v1/unban", form, inSession("s1"))
+	if rec.Code != http.StatusSeeOther || jail.Jailed("203.0.113.5") {
+		t.Fatalf("form unban failed: %d %s \n", rec.Code, rec.Body.String())
+	}
+
+	//a failed unban keeps the score
+	prev := watch.Watchlist
+	watch.Watchlist = map[string]float32{"203.0.113.6": 50}
+	defer func() { watch.Watchlist = prev }()
+	form = url.Values{"ip": {"203.0.113.6"}}
+	rec = post(mux, "/api/v1/unban", form, func(r *http.Request) { r.Header.Set("Authorization", "Bearer tok3n") })
+	if rec.Code != http.StatusBadRequest || watch.Score("203.0.113.6") != 50 {
+		t.Fatalf("score dropped by a failed unban: %d %.0f \n", rec.Code, watch.Score("203.0.113.6"))
+	}
+}
+
+func TestSession(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/stats", nil)
+	rec := httptest.NewRecorder()
+	id := session(rec, req)
+	cookies := rec.Result().Cookies()
+	if id == "" || len(cookies) != 1 || cookies[0].Value != id || !cookies[0].HttpOnly {
+		t.Fatalf("session not started: %q %v \n", id, cookies)
+	}
+
+	//kept once started
+	req.AddCookie(cookies[0])
+	rec = httptest.NewRecorder()
+	if session(rec, req) != id || len(rec.Result().Cookies()) != 0 {
+		t.Fatalf("session not kept \n")
+	}
+}
+
+func TestStatsTemplate(t *testing.T) {
+	tmpl := template.Must(template.ParseFiles("../template/index.html"))
+	data := StatPageData{
+		Watchlist:  []stat{{"192.0.2.1", 50, "host", "", "", "AS64496 Example Hosting"}},
+		Jaillist:   []stat{{"192.0.2.2", 60, "host", "", "drop", ""}},
+		User:       "admin",
+		CSRFToken:  csrfToken("admin", "s1"),
+		Whitelist:  []string{"127.0.0.1/32"},
+		Blocklists: []blocklist.Status{{Name: "drop", URL: "drop.txt", Entries: 1, Updated: time.Now()}},
+	}
+	err := tmpl.Execute(ioutil.Discard, data)
+	if err != nil {
+		t.Fatalf("template error: %s \n", err.Error())
+	}
+}
@@ -0,0 +1,143 @@
+package web
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"ipvoid/config"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// DefaultListen is the address of the web interface when WebListen is not set
+const DefaultListen = ":9900"
+
+var server *http.Server
+
+// certReloader serves the TLS certificate and reloads it when the files change
+type certReloader struct {
+	certFile string
+	keyFile  string
+	lock     sync.Mutex
+	cert     *tls.Certificate
+	modTime  time.Time
+}
+
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	cr := &certReloader{certFile: certFile, keyFile: keyFile}
+	err := cr.load()
+	if err != nil {
+		return nil, err
+	}
+	return cr, nil
+}
+
+func (cr *certReloader) modified() time.Time {
+	var latest time.Time
+	for _, f := range []string{cr.certFile, cr.keyFile} {
+		if fi, err := os.Stat(f); err == nil && fi.ModTime().After(latest) {
+			latest = fi.ModTime()
+		}
+	}
+	return latest
+}
+
+func (cr *certReloader) load() error {
+	modTime := cr.modified()
+	cert, err := tls.LoadX509KeyPair(cr.certFile, cr.keyFile)
+	if err != nil {
+		return err
+	}
+	cr.cert = &cert
+	cr.modTime = modTime
+	return nil
+}
+
+func (cr *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cr.lock.Lock()
+	defer cr.lock.Unlock()
+
+	if cr.modified().After(cr.modTime) {
+		err := cr.load()
+		if err != nil {
+			//keep serving the old certificate until the new one is complete
+			log.Printf("Web: TLS certificate reload failed: %s \n", err.Error())
+		} else {
+			log.Println("Web: TLS certificate reloaded")
+		}
+	}
+	return cr.cert, nil
+}
+
+// listeners opens the TCP (optionally TLS) and Unix socket listeners
+func listeners() ([]net.Listener, error) {
+	var ls []net.Listener
+
+	conf := config.Get()
+	addr := conf.WebListen
+	if addr == "" {
+		addr = DefaultListen
+	}
+
+	if addr != "off" {
+		l, err := net.Listen("tcp", addr)
+		if err != nil {
+			return nil, err
+		}
+
+		if conf.WebTLSCert != "" || conf.WebTLSKey != "" {
+			if conf.WebTLSCert == "" || conf.WebTLSKey == "" {
+				l.Close()
+				return nil, errors.New("both WebTLSCert and WebTLSKey are needed for TLS")
+			}
+			cr, err := newCertReloader(conf.WebTLSCert, conf.WebTLSKey)
+			if err != nil {
+				l.Close()
+				return nil, err
+			}
+			l = tls.NewListener(l, &tls.Config{
+				GetCertificate: cr.GetCertificate,
+				MinVersion:     tls.VersionTLS12,
+			})
+		}
+		ls = append(ls, l)
+	}
+
+	if conf.WebSocket != "" {
+		//remove a socket left by a previous run
+		os.Remove(conf.WebSocket)
+		l, err := net.Listen("unix", conf.WebSocket)
+		if err == nil {
+			err = os.Chmod(conf.WebSocket, 0660)
+		}
+		if err != nil {
+			for _, l := range ls {
+				l.Close()
+			}
+			return nil, err
+		}
+		ls = append(ls, l)
+	}
+
+	if len(ls) == 0 {
+		return nil, errors.New("web interface has no listener, set WebListen or WebSocket")
+	}
+	return ls, nil
+}
+
+// Shutdown stops the web interface, waiting up to timeout for open requests
+func Shutdown(timeout time.Duration) {
+	if server == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	err := server.Shutdown(ctx)
+	if err != nil {
+		log.Printf("Web: shutdown issue: %s \n", err.Error())
+	}
+}
@@ -0,0 +1,111 @@
+package web
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"ipvoid/config"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeCert(t *testing.T, certFile, keyFile string, serial int64) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{"localhost"},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, _ := x509.MarshalECPrivateKey(key)
+	ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600)
+}
+
+func TestListeners(t *testing.T) {
+	dir, err := ioutil.TempDir("", "web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	writeCert(t, certFile, keyFile, 1)
+
+	defer config.Set(config.Get())
+	config.Set(&config.Configuration{
+		WebListen:  "127.0.0.1:0",
+		WebTLSCert: certFile,
+		WebTLSKey:  keyFile,
+		WebSocket:  filepath.Join(dir, "web.sock"),
+	})
+
+	ls, err := listeners()
+	if err != nil {
+		t.Fatalf("listeners failed: %s \n", err.Error())
+	}
+	if len(ls) != 2 {
+		t.Fatalf("expected 2 listeners, got %d \n", len(ls))
+	}
+
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})}
+	for _, l := range ls {
+		go srv.Serve(l)
+	}
+	defer srv.Shutdown(context.Background())
+
+	serial := func() int64 {
+		conn, err := tls.Dial("tcp", ls[0].Addr().String(), &tls.Config{InsecureSkipVerify: true})
+		if err != nil {
+			t.Fatalf("TLS dial failed: %s \n", err.Error())
+		}
+		defer conn.Close()
+		return conn.ConnectionState().PeerCertificates[0].SerialNumber.Int64()
+	}
+
+	if s := serial(); s != 1 {
+		t.Fatalf("expected certificate 1, got %d \n", s)
+	}
+
+	//renewed certificate is picked up without a restart
+	writeCert(t, certFile, keyFile, 2)
+	future := time.Now().Add(time.Minute)
+	os.Chtimes(certFile, future, future)
+	os.Chtimes(keyFile, future, future)
+	if s := serial(); s != 2 {
+		t.Fatalf("expected reloaded certificate 2, got %d \n", s)
+	}
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return net.Dial("unix", config.Get().WebSocket)
+		},
+	}}
+	res, err := client.Get("http://unix/")
+	if err != nil {
+		t.Fatalf("Unix socket request failed: %s \n", err.Error())
+	}
+	res.Body.Close()
+
+	config.Set(&config.Configuration{WebListen: "127.0.0.1:0", WebTLSCert: certFile})
+	if _, err := listeners(); err == nil {
+		t.Fatalf("expected error for a missing TLS key \n")
+	}
+}
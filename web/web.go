@@ -1,38 +1,82 @@
 package web
 
 import (
+	"fmt"
 	"html/template"
+	"ipvoid/blocklist"
 	"ipvoid/jail"
+	"ipvoid/metrics"
 	"ipvoid/resolver"
 	"ipvoid/voidlog"
 	"ipvoid/watch"
+	"log"
+	"net"
 	"net/http"
 	"sort"
+	"strings"
+	"time"
 )
 
 type StatPageData struct {
-	Watchlist []stat
-	Jaillist  []stat
-	History   []string
-	Log       []string
+	Watchlist   []stat
+	Jaillist    []stat
+	History     []string
+	Log         []string
+	AuthEnabled bool
+	User        string //set when the write controls are shown
+	CSRFToken   string
+	Whitelist   []string
+	DryRun      bool
+	WouldBans   []jail.WouldBan
+	Blocklists  []blocklist.Status
 }
 
 type stat struct {
-	IP    string
-	Score float32
-	Host  string
+	IP     string
+	Score  float32
+	Host   string
+	Fields string
+	Lists  string //the blocklists of the IP
+	ASN    string //"AS<number> <organisation>"
 }
 
-func init() {
+var tmpl *template.Template
 
-}
+// Webserver opens the configured listeners and serves the web interface in
+// the background. Errors are returned when a listener can't be opened.
+func Webserver() error {
+	var err error
+	tmpl, err = template.ParseFiles("template/index.html")
+	if err != nil {
+		return err
+	}
 
-var tmpl *template.Template
+	mux := http.NewServeMux()
+	mux.HandleFunc("/stats", statsPage)
+	mux.Handle("/metrics", metrics.Handler())
+	registerAPI(mux)
+	registerActions(mux)
+
+	ls, err := listeners()
+	if err != nil {
+		return err
+	}
+
+	server = &http.Server{
+		Handler:      mux,
+		ReadTimeout:  30 * time.Second,
+		WriteTimeout: 60 * time.Second,
+	}
 
-func Webserver() {
-	tmpl = template.Must(template.ParseFiles("template/index.html"))
-	http.HandleFunc("/stats", statsPage)
-	http.ListenAndServe(":9900", nil)
+	for _, l := range ls {
+		go func(l net.Listener) {
+			err := server.Serve(l)
+			if err != nil && err != http.ErrServerClosed {
+				log.Printf("Web: %s: %s \n", l.Addr(), err.Error())
+			}
+		}(l)
+	}
+	return nil
 }
 
 func statsPage(w http.ResponseWriter, r *http.Request) {
@@ -44,9 +88,9 @@ func statsPage(w http.ResponseWriter, r *http.Request) {
 	var log []string
 
 	//sorting watch list
-	for k, v := range watch.Watchlist {
+	for k, v := range watch.Snapshot() {
 		host := resolver.Lookup(k)
-		statWatch = append(statWatch, stat{k, v, host})
+		statWatch = append(statWatch, stat{k, v, host, watch.FormatFields(watch.Fields(k)), strings.Join(jail.Listed(k), ","), asn(k)})
 	}
 
 	sort.Slice(statWatch, func(i, j int) bool {
@@ -54,9 +98,9 @@ func statsPage(w http.ResponseWriter, r *http.Request) {
 	})
 
 	//sorting jail list
-	for k, v := range jail.Ip_list {
+	for k, v := range jail.Jail() {
 		host := resolver.Lookup(k)
-		statJail = append(statJail, stat{k, v, host})
+		statJail = append(statJail, stat{k, v, host, watch.FormatFields(watch.Fields(k)), strings.Join(jail.Listed(k), ","), asn(k)})
 	}
 
 	sort.Slice(statJail, func(i, j int) bool {
@@ -64,15 +108,7 @@ func statsPage(w http.ResponseWriter, r *http.Request) {
 	})
 
 	//copy jail history data
-	jail.JailHistory.Do(func(p interface{}) {
-		if p != nil {
-			stathistory = append(stathistory, p.(string))
-		}
-	})
-	//reverse history
-	for i, j := 0, len(stathistory)-1; i < j; i, j = i+1, j-1 {
-		stathistory[i], stathistory[j] = stathistory[j], stathistory[i]
-	}
+	stathistory = jail.History()
 
 	//copy log data
 	voidlog.LogHistory.Do(func(p interface{}) {
@@ -89,5 +125,27 @@ func statsPage(w http.ResponseWriter, r *http.Request) {
 	data.Jaillist = statJail
 	data.History = stathistory
 	data.Log = log
+	data.Blocklists = blocklist.Lists()
+	data.DryRun = jail.DryRun()
+	if data.DryRun {
+		data.WouldBans = jail.WouldBans()
+	}
+
+	//write controls for logged in users
+	data.AuthEnabled = authEnabled()
+	if user, basic, ok := authenticate(r); ok && basic {
+		data.User = user
+		data.CSRFToken = csrfToken(user, session(w, r))
+		data.Whitelist = jail.Whitelist()
+	}
 	tmpl.Execute(w, data)
 }
+
+// asn describes the AS of ip, "" when it's unknown
+func asn(ip string) string {
+	number, org := watch.ASN(ip)
+	if number == 0 {
+		return ""
+	}
+	return strings.TrimSpace(fmt.Sprintf("AS%d %s", number, org))
+}